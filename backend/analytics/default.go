@@ -0,0 +1,23 @@
+package analytics
+
+import (
+	"time"
+
+	"shorter-backend/config"
+)
+
+// Default is the pipeline the redirect handlers feed; Init creates it
+var Default *Pipeline
+
+// Init creates the default pipeline from the environment. Register
+// enrichers and sinks on Default, then call Default.Start.
+func Init() {
+	Default = New(Config{
+		QueueSize:     config.GetEnvInt("CLICK_QUEUE_SIZE", 10000),
+		Workers:       config.GetEnvInt("CLICK_WORKERS", 2),
+		BatchSize:     config.GetEnvInt("CLICK_BATCH_SIZE", 100),
+		FlushInterval: config.GetEnvDuration("CLICK_FLUSH_INTERVAL", time.Second),
+		DropPolicy:    DropPolicy(config.GetEnv("CLICK_DROP_POLICY", string(DropNewest))),
+		BlockTimeout:  config.GetEnvDuration("CLICK_BLOCK_TIMEOUT", 50*time.Millisecond),
+	})
+}
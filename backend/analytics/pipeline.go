@@ -0,0 +1,279 @@
+// Package analytics ingests clicks off the redirect path: hits are queued
+// in memory and a small pool of workers writes them to the store in batches.
+package analytics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shorter-backend/models"
+	"shorter-backend/store"
+)
+
+// DropPolicy decides what happens to a hit when the queue is full
+type DropPolicy string
+
+const (
+	// DropNewest discards the incoming hit
+	DropNewest DropPolicy = "drop_newest"
+	// DropOldest discards the oldest queued hit to make room
+	DropOldest DropPolicy = "drop_oldest"
+	// Block waits up to BlockTimeout for room before discarding the hit
+	Block DropPolicy = "block"
+)
+
+// Hit is a click waiting to be written, together with the request details
+// enrichers may need
+type Hit struct {
+	Click  models.Click
+	Method string
+	Header http.Header
+}
+
+// Enricher fills in fields of a click before it is written
+type Enricher func(hit *Hit)
+
+// Sink receives every batch of clicks after it has been written
+type Sink func(ctx context.Context, clicks []models.Click)
+
+// Config tunes the size and behaviour of a pipeline
+type Config struct {
+	QueueSize     int
+	Workers       int
+	BatchSize     int
+	FlushInterval time.Duration
+	DropPolicy    DropPolicy
+	BlockTimeout  time.Duration
+}
+
+// Stats are the pipeline counters exposed for monitoring
+type Stats struct {
+	Enqueued      uint64 `json:"enqueued"`
+	Dropped       uint64 `json:"dropped"`
+	Written       uint64 `json:"written"`
+	Failed        uint64 `json:"failed"`
+	Batches       uint64 `json:"batches"`
+	QueueLength   int    `json:"queue_length"`
+	QueueCapacity int    `json:"queue_capacity"`
+}
+
+// Pipeline is a bounded click queue drained by a pool of batching workers
+type Pipeline struct {
+	cfg   Config
+	queue chan *Hit
+
+	enrichers []Enricher
+	sinks     []Sink
+
+	enqueued atomic.Uint64
+	dropped  atomic.Uint64
+	written  atomic.Uint64
+	failed   atomic.Uint64
+	batches  atomic.Uint64
+
+	// mu guards closed so Enqueue never sends on a closed queue
+	mu      sync.RWMutex
+	closed  bool
+	started bool
+	wg      sync.WaitGroup
+}
+
+// New creates a pipeline; call Start to launch its workers
+func New(cfg Config) *Pipeline {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.DropPolicy == "" {
+		cfg.DropPolicy = DropNewest
+	}
+	if cfg.BlockTimeout <= 0 {
+		cfg.BlockTimeout = 50 * time.Millisecond
+	}
+
+	return &Pipeline{
+		cfg:   cfg,
+		queue: make(chan *Hit, cfg.QueueSize),
+	}
+}
+
+// Use registers an enricher. Enrichers run on the workers, in the order
+// they were registered, and must be added before Start.
+func (p *Pipeline) Use(e Enricher) {
+	p.enrichers = append(p.enrichers, e)
+}
+
+// OnWrite registers a sink for written batches; add sinks before Start
+func (p *Pipeline) OnWrite(s Sink) {
+	p.sinks = append(p.sinks, s)
+}
+
+// Start launches the workers
+func (p *Pipeline) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.started {
+		return
+	}
+	p.started = true
+
+	for i := 0; i < p.cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+}
+
+// Enqueue hands a hit to the pipeline without waiting for it to be written.
+// It reports false when the hit was dropped.
+func (p *Pipeline) Enqueue(hit *Hit) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		p.dropped.Add(1)
+		return false
+	}
+
+	select {
+	case p.queue <- hit:
+		p.enqueued.Add(1)
+		return true
+	default:
+	}
+
+	switch p.cfg.DropPolicy {
+	case DropOldest:
+		// Make room by discarding the oldest hit, then try once more
+		select {
+		case <-p.queue:
+			p.dropped.Add(1)
+		default:
+		}
+		select {
+		case p.queue <- hit:
+			p.enqueued.Add(1)
+			return true
+		default:
+		}
+	case Block:
+		timer := time.NewTimer(p.cfg.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case p.queue <- hit:
+			p.enqueued.Add(1)
+			return true
+		case <-timer.C:
+		}
+	}
+
+	p.dropped.Add(1)
+	return false
+}
+
+// Stop stops accepting hits and waits for the workers to flush what is
+// queued, giving up when ctx is done
+func (p *Pipeline) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.queue)
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the pipeline counters
+func (p *Pipeline) Stats() Stats {
+	return Stats{
+		Enqueued:      p.enqueued.Load(),
+		Dropped:       p.dropped.Load(),
+		Written:       p.written.Load(),
+		Failed:        p.failed.Load(),
+		Batches:       p.batches.Load(),
+		QueueLength:   len(p.queue),
+		QueueCapacity: cap(p.queue),
+	}
+}
+
+// worker drains the queue, flushing whenever a batch fills up or the flush
+// interval passes, and once more when the queue is closed
+func (p *Pipeline) worker() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Click, 0, p.cfg.BatchSize)
+	for {
+		select {
+		case hit, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			for _, enrich := range p.enrichers {
+				enrich(hit)
+			}
+			batch = append(batch, hit.Click)
+			if len(batch) >= p.cfg.BatchSize {
+				p.flush(batch)
+				batch = make([]models.Click, 0, p.cfg.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				p.flush(batch)
+				batch = make([]models.Click, 0, p.cfg.BatchSize)
+			}
+		}
+	}
+}
+
+// flush writes one batch and passes it on to the sinks
+func (p *Pipeline) flush(batch []models.Click) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// Writes are detached from shutdown so the final flush can complete
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p.batches.Add(1)
+	if err := store.Clicks.CreateBatch(ctx, batch); err != nil {
+		p.failed.Add(uint64(len(batch)))
+		log.Printf("Failed to write %d clicks: %v", len(batch), err)
+		return
+	}
+	p.written.Add(uint64(len(batch)))
+
+	for _, sink := range p.sinks {
+		sink(ctx, batch)
+	}
+}
@@ -0,0 +1,149 @@
+// Package botdetect flags clicks made by crawlers, link unfurlers, uptime
+// monitors and scanners so they can be kept out of analytics.
+package botdetect
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"shorter-backend/analytics"
+	"shorter-backend/config"
+	"shorter-backend/useragent"
+)
+
+// Reasons a click was classified as a bot
+const (
+	ReasonUserAgent      = "user_agent"
+	ReasonIPRange        = "ip_range"
+	ReasonHeadRequest    = "head_request"
+	ReasonMissingHeaders = "missing_headers"
+)
+
+// Classifier decides whether a hit came from a bot
+type Classifier struct {
+	ranges []*net.IPNet
+}
+
+// New creates a classifier that also flags clients inside ranges
+func New(ranges []*net.IPNet) *Classifier {
+	return &Classifier{ranges: ranges}
+}
+
+// LoadRanges reads CIDRs (or single addresses), one per line, from path.
+// Blank lines and lines starting with # are skipped.
+func LoadRanges(path string) ([]*net.IPNet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ranges []*net.IPNet
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		cidr := entry
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Skipping invalid bot IP range on line %d of %s: %q", line, path, entry)
+			continue
+		}
+		ranges = append(ranges, network)
+	}
+	return ranges, scanner.Err()
+}
+
+// Classify reports whether the hit looks automated and why. The checks run
+// from most to least certain.
+func (c *Classifier) Classify(hit *analytics.Hit) (bool, string) {
+	if hit.Click.UserAgent == "" {
+		return true, ReasonMissingHeaders
+	}
+	if useragent.IsBot(hit.Click.UserAgent) {
+		return true, ReasonUserAgent
+	}
+	if c.inRanges(hit.Click.IPAddress) {
+		return true, ReasonIPRange
+	}
+	if hit.Method == http.MethodHead {
+		return true, ReasonHeadRequest
+	}
+	if missingBrowserHeaders(hit.Header) {
+		return true, ReasonMissingHeaders
+	}
+	return false, ""
+}
+
+// Enrich is an analytics enricher that flags bot clicks
+func (c *Classifier) Enrich(hit *analytics.Hit) {
+	hit.Click.IsBot, hit.Click.BotReason = c.Classify(hit)
+}
+
+func (c *Classifier) inRanges(ip string) bool {
+	if len(c.ranges) == 0 {
+		return false
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range c.ranges {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// missingBrowserHeaders spots scripted clients: browsers navigating to a
+// link always send Accept-Language and a specific Accept header, while HTTP
+// libraries typically send neither. A nil header (no request details) is
+// given the benefit of the doubt.
+func missingBrowserHeaders(header http.Header) bool {
+	if header == nil {
+		return false
+	}
+	if header.Get("Accept-Language") != "" {
+		return false
+	}
+	accept := header.Get("Accept")
+	return accept == "" || accept == "*/*"
+}
+
+// Default is the classifier used for click enrichment
+var Default = New(nil)
+
+// Init loads the crawler IP ranges named by BOT_IP_RANGES_FILE. Without the
+// file, bots are still recognised by their requests.
+func Init() {
+	path := config.GetEnv("BOT_IP_RANGES_FILE", "")
+	if path == "" {
+		return
+	}
+
+	ranges, err := LoadRanges(path)
+	if err != nil {
+		log.Printf("Failed to load bot IP ranges: %v", err)
+		return
+	}
+
+	Default = New(ranges)
+	log.Printf("Loaded %d bot IP ranges", len(ranges))
+}
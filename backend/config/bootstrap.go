@@ -0,0 +1,67 @@
+package config
+
+import (
+	"log"
+	"strings"
+
+	"shorter-backend/models"
+	"shorter-backend/store"
+	"shorter-backend/utils"
+)
+
+// BootstrapAdmin makes sure the user named by ADMIN_EMAIL exists and holds
+// the admin role. When ADMIN_API_KEY is set that key is registered for the
+// user; otherwise a key is generated and logged once if the admin has none.
+func BootstrapAdmin() {
+	email := strings.ToLower(strings.TrimSpace(getEnv("ADMIN_EMAIL", "")))
+	if email == "" {
+		return
+	}
+
+	admin, err := store.Users.FindByEmail(Ctx, email)
+	if err != nil {
+		admin = &models.User{
+			Email: email,
+			Name:  "Administrator",
+			Role:  models.RoleAdmin,
+		}
+		if err := store.Users.Create(Ctx, admin); err != nil {
+			log.Fatal("Failed to create admin user:", err)
+		}
+		log.Printf("Created admin user %s", email)
+	} else if admin.Role != models.RoleAdmin {
+		if err := store.Users.UpdateRole(Ctx, admin, models.RoleAdmin); err != nil {
+			log.Fatal("Failed to promote admin user:", err)
+		}
+		log.Printf("Promoted %s to admin", email)
+	}
+
+	key := getEnv("ADMIN_API_KEY", "")
+	if key == "" {
+		if activeKeys, _ := store.Users.CountActiveAPIKeys(Ctx, admin.ID); activeKeys > 0 {
+			return
+		}
+
+		generated, err := utils.GenerateAPIKey()
+		if err != nil {
+			log.Fatal("Failed to generate admin API key:", err)
+		}
+		key = generated
+		log.Printf("Generated admin API key (shown only once): %s", key)
+	}
+
+	if _, err := store.Users.FindAPIKeyByHash(Ctx, utils.HashAPIKey(key)); err == nil {
+		return
+	}
+
+	apiKey := models.APIKey{
+		UserID:  admin.ID,
+		Name:    "bootstrap",
+		Prefix:  key[:min(len(key), utils.APIKeyDisplayLength)],
+		KeyHash: utils.HashAPIKey(key),
+		Scopes:  strings.Join(models.AllScopes, " "),
+	}
+	if err := store.Users.CreateAPIKey(Ctx, &apiKey); err != nil {
+		log.Fatal("Failed to store admin API key:", err)
+	}
+}
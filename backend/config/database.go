@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"shorter-backend/models"
 
+	"github.com/glebarez/sqlite"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -21,19 +21,37 @@ var (
 	Ctx = context.Background()
 )
 
+// ConnectDatabase opens the database selected by DB_DRIVER (postgres,
+// sqlite or memory). With the memory driver DB stays nil and the store
+// package keeps everything in process.
 func ConnectDatabase() {
-	// Database connection
-	host := getEnv("DB_HOST", "localhost")
-	user := getEnv("DB_USER", "postgres")
-	password := getEnv("DB_PASSWORD", "password")
-	dbname := getEnv("DB_NAME", "shorter_db")
-	port := getEnv("DB_PORT", "5432")
-	sslmode := getEnv("DB_SSLMODE", "disable")
-
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=Asia/Jakarta",
-		host, user, password, dbname, port, sslmode)
-
-	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+	driver := getEnv("DB_DRIVER", "postgres")
+
+	var dialector gorm.Dialector
+	switch driver {
+	case "postgres":
+		// Database connection
+		host := getEnv("DB_HOST", "localhost")
+		user := getEnv("DB_USER", "postgres")
+		password := getEnv("DB_PASSWORD", "password")
+		dbname := getEnv("DB_NAME", "shorter_db")
+		port := getEnv("DB_PORT", "5432")
+		sslmode := getEnv("DB_SSLMODE", "disable")
+		timezone := getEnv("DB_TIMEZONE", "UTC")
+
+		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=%s",
+			host, user, password, dbname, port, sslmode, timezone)
+		dialector = postgres.Open(dsn)
+	case "sqlite":
+		dialector = sqlite.Open(getEnv("SQLITE_PATH", "shorter.db"))
+	case "memory":
+		log.Println("Using in-memory storage, data will not survive a restart")
+		return
+	default:
+		log.Fatalf("Unknown DB_DRIVER %q (expected postgres, sqlite or memory)", driver)
+	}
+
+	database, err := gorm.Open(dialector, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 	})
 
@@ -44,12 +62,12 @@ func ConnectDatabase() {
 	DB = database
 
 	// Auto migrate the schema
-	err = DB.AutoMigrate(&models.URL{}, &models.Click{})
+	err = DB.AutoMigrate(&models.URL{}, &models.Click{}, &models.ClickRollup{}, &models.Conversion{}, &models.User{}, &models.APIKey{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
-	log.Println("Database connected successfully")
+	log.Printf("Database connected successfully (%s)", driver)
 }
 
 func ConnectRedis() {
@@ -75,6 +93,23 @@ func ConnectRedis() {
 	log.Println("Redis connected successfully")
 }
 
+// Close releases the database and Redis connections
+func Close() error {
+	if RDB != nil {
+		if err := RDB.Close(); err != nil {
+			log.Printf("Failed to close Redis: %v", err)
+		}
+	}
+	if DB != nil {
+		sqlDB, err := DB.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.Close()
+	}
+	return nil
+}
+
 func CacheSet(key string, value string, expiration time.Duration) error {
 	if RDB == nil {
 		return nil // Skip if Redis is not available
@@ -95,10 +130,4 @@ func CacheDelete(key string) error {
 	}
 	return RDB.Del(Ctx, key).Err()
 }
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-} 
\ No newline at end of file
+ 
\ No newline at end of file
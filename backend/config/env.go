@@ -0,0 +1,51 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// GetEnv returns the environment variable or defaultValue when it is unset
+func GetEnv(key, defaultValue string) string {
+	return getEnv(key, defaultValue)
+}
+
+// GetEnvInt returns the environment variable parsed as an integer, falling
+// back to defaultValue when it is unset or invalid
+func GetEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// GetEnvDuration returns the environment variable parsed as a duration
+// (e.g. "500ms", "30s"), falling back to defaultValue when it is unset or
+// invalid
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
@@ -0,0 +1,252 @@
+// Package geoip resolves client IPs to a location using offline
+// MaxMind-format (MMDB) databases, reloading them when the files change.
+package geoip
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"shorter-backend/analytics"
+	"shorter-backend/config"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is what the databases know about an IP address
+type Location struct {
+	Country string // ISO 3166-1 alpha-2 code, e.g. "ID"
+	Region  string // ISO 3166-2 code, e.g. "ID-JK"
+	City    string // English name
+	ASN     uint
+	ASOrg   string
+}
+
+// cityRecord is the subset of a GeoIP2/GeoLite2 City or Country record we read
+type cityRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// asnRecord is a GeoLite2 ASN record
+type asnRecord struct {
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// database is one MMDB file that is reopened whenever it is replaced
+type database struct {
+	path string
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// openDatabase loads the file at path
+func openDatabase(path string) (*database, error) {
+	db := &database{path: path}
+	if err := db.reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// reload reopens the file if it changed since it was last loaded. The old
+// reader is closed only once no lookup is using it.
+func (db *database) reload() error {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return err
+	}
+
+	db.mu.RLock()
+	unchanged := db.reader != nil && info.ModTime().Equal(db.modTime) && info.Size() == db.size
+	db.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	old := db.reader
+	db.reader = reader
+	db.modTime = info.ModTime()
+	db.size = info.Size()
+	db.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+		log.Printf("Reloaded GeoIP database %s", db.path)
+	}
+	return nil
+}
+
+// lookup decodes the record for ip into result
+func (db *database) lookup(ip net.IP, result interface{}) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.reader.Lookup(ip, result)
+}
+
+func (db *database) close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.reader.Close()
+}
+
+// Resolver looks up IPs in a city database and, optionally, an ASN database
+type Resolver struct {
+	city *database
+	asn  *database
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// Open loads the given databases; either path may be empty. The files are
+// checked for changes every reloadInterval.
+func Open(cityPath, asnPath string, reloadInterval time.Duration) (*Resolver, error) {
+	r := &Resolver{stop: make(chan struct{})}
+
+	var err error
+	if cityPath != "" {
+		if r.city, err = openDatabase(cityPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if r.asn, err = openDatabase(asnPath); err != nil {
+			if r.city != nil {
+				r.city.close()
+			}
+			return nil, err
+		}
+	}
+
+	if reloadInterval > 0 {
+		go r.watch(reloadInterval)
+	}
+	return r, nil
+}
+
+// Lookup returns the location of ip. Unknown or unparsable addresses, and a
+// nil resolver, yield an empty Location.
+func (r *Resolver) Lookup(ip string) Location {
+	var loc Location
+	if r == nil {
+		return loc
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return loc
+	}
+
+	if r.city != nil {
+		var rec cityRecord
+		if err := r.city.lookup(parsed, &rec); err == nil {
+			loc.Country = rec.Country.ISOCode
+			loc.City = rec.City.Names["en"]
+			if len(rec.Subdivisions) > 0 && rec.Subdivisions[0].ISOCode != "" && loc.Country != "" {
+				loc.Region = loc.Country + "-" + rec.Subdivisions[0].ISOCode
+			}
+		}
+	}
+
+	if r.asn != nil {
+		var rec asnRecord
+		if err := r.asn.lookup(parsed, &rec); err == nil {
+			loc.ASN = rec.ASN
+			loc.ASOrg = rec.ASOrg
+		}
+	}
+
+	return loc
+}
+
+// Enrich is an analytics enricher that fills in the click's location
+func (r *Resolver) Enrich(hit *analytics.Hit) {
+	loc := r.Lookup(hit.Click.IPAddress)
+	hit.Click.Country = loc.Country
+	hit.Click.Region = loc.Region
+	hit.Click.City = loc.City
+	hit.Click.ASN = loc.ASN
+	hit.Click.ASOrg = loc.ASOrg
+}
+
+// Close stops watching the files and releases the databases
+func (r *Resolver) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.once.Do(func() { close(r.stop) })
+	if r.city != nil {
+		r.city.close()
+	}
+	if r.asn != nil {
+		r.asn.close()
+	}
+	return nil
+}
+
+// watch polls the database files and reloads any that were replaced
+func (r *Resolver) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, db := range []*database{r.city, r.asn} {
+				if db == nil {
+					continue
+				}
+				if err := db.reload(); err != nil {
+					log.Printf("Failed to reload GeoIP database %s: %v", db.path, err)
+				}
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Default is the resolver used for click enrichment; nil when no database
+// is configured
+var Default *Resolver
+
+// Init opens the databases named by GEOIP_CITY_DB and GEOIP_ASN_DB. Missing
+// configuration or unreadable files leave geolocation disabled.
+func Init() {
+	cityPath := config.GetEnv("GEOIP_CITY_DB", "")
+	asnPath := config.GetEnv("GEOIP_ASN_DB", "")
+	if cityPath == "" && asnPath == "" {
+		log.Println("GeoIP disabled (set GEOIP_CITY_DB / GEOIP_ASN_DB to enable)")
+		return
+	}
+
+	resolver, err := Open(cityPath, asnPath, config.GetEnvDuration("GEOIP_RELOAD_INTERVAL", time.Minute))
+	if err != nil {
+		log.Printf("Failed to open GeoIP database: %v", err)
+		log.Println("Continuing without GeoIP...")
+		return
+	}
+
+	Default = resolver
+	log.Println("GeoIP databases loaded successfully")
+}
@@ -3,10 +3,15 @@ package handlers
 import (
 	"net/http"
 	"runtime"
+	"strconv"
 	"time"
 
+	"shorter-backend/analytics"
 	"shorter-backend/config"
+	"shorter-backend/lifecycle"
+	"shorter-backend/middleware"
 	"shorter-backend/models"
+	"shorter-backend/store"
 
 	"github.com/gin-gonic/gin"
 )
@@ -29,7 +34,10 @@ type SystemStats struct {
 	TotalClicks      int64     `json:"total_clicks"`
 	URLsToday        int64     `json:"urls_today"`
 	ClicksToday      int64     `json:"clicks_today"`
-	TopDomains       []DomainStat `json:"top_domains"`
+	TopDomains       []models.DomainStat `json:"top_domains"`
+	
+	// Click ingestion
+	ClickPipeline    analytics.Stats `json:"click_pipeline"`
 	
 	// System status
 	Uptime           string    `json:"uptime"`
@@ -38,11 +46,6 @@ type SystemStats struct {
 	LastHealthCheck  time.Time `json:"last_health_check"`
 }
 
-type DomainStat struct {
-	Domain string `json:"domain"`
-	Count  int64  `json:"count"`
-}
-
 var startTime = time.Now()
 
 // GetSystemStats returns comprehensive system statistics
@@ -63,25 +66,22 @@ func GetSystemStats(c *gin.Context) {
 	stats.NumGC = m.NumGC
 	
 	// Database stats
-	config.DB.Model(&models.URL{}).Count(&stats.TotalURLs)
-	config.DB.Model(&models.Click{}).Count(&stats.TotalClicks)
+	ctx := c.Request.Context()
+	filter := clickFilter(c)
+	stats.TotalURLs, _ = store.Links.CountSince(ctx, time.Time{})
+	stats.TotalClicks, _ = store.Rollups.Sum(ctx, store.RollupQuery{Granularity: models.GranularityDay, Filter: filter})
 	
 	// Today's stats
-	today := time.Now().Format("2006-01-02")
-	config.DB.Model(&models.URL{}).Where("DATE(created_at) = ?", today).Count(&stats.URLsToday)
-	config.DB.Model(&models.Click{}).Where("DATE(created_at) = ?", today).Count(&stats.ClicksToday)
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	stats.URLsToday, _ = store.Links.CountSince(ctx, today)
+	stats.ClicksToday, _ = store.Rollups.Sum(ctx, store.RollupQuery{Granularity: models.GranularityHour, From: today, Filter: filter})
 	
 	// Top domains (last 30 days)
-	config.DB.Raw(`
-		SELECT 
-			REGEXP_REPLACE(original_url, '^https?://(?:www\.)?([^/]+).*', '\1') as domain,
-			COUNT(*) as count
-		FROM urls 
-		WHERE created_at >= NOW() - INTERVAL '30 days'
-		GROUP BY domain 
-		ORDER BY count DESC 
-		LIMIT 10
-	`).Scan(&stats.TopDomains)
+	stats.TopDomains, _ = store.Links.TopDomains(ctx, now.AddDate(0, 0, -30), 10)
+	
+	// Click pipeline counters
+	stats.ClickPipeline = analytics.Default.Stats()
 	
 	// System status
 	stats.Uptime = time.Since(startTime).String()
@@ -96,20 +96,23 @@ func GetSystemStats(c *gin.Context) {
 func GetDetailedHealth(c *gin.Context) {
 	health := gin.H{
 		"status":    "ok",
+		"ready":     lifecycle.IsReady(),
 		"timestamp": time.Now(),
 		"services":  gin.H{},
 	}
 	
 	// Check database
-	if err := config.DB.Exec("SELECT 1").Error; err != nil {
+	if err := store.Ping(c.Request.Context()); err != nil {
 		health["status"] = "degraded"
 		health["services"].(gin.H)["database"] = gin.H{
 			"status": "down",
+			"driver": store.Name(),
 			"error":  err.Error(),
 		}
 	} else {
 		health["services"].(gin.H)["database"] = gin.H{
 			"status": "up",
+			"driver": store.Name(),
 		}
 	}
 	
@@ -132,6 +135,14 @@ func GetDetailedHealth(c *gin.Context) {
 		}
 	}
 	
+	// Not ready while starting up or draining before shutdown, so load
+	// balancers stop sending traffic
+	if !lifecycle.IsReady() {
+		health["status"] = "draining"
+		c.JSON(http.StatusServiceUnavailable, health)
+		return
+	}
+	
 	c.JSON(http.StatusOK, health)
 }
 
@@ -139,14 +150,13 @@ func GetDetailedHealth(c *gin.Context) {
 func GetRecentActivity(c *gin.Context) {
 	limit := 50
 	
-	var recentURLs []models.URL
-	var recentClicks []models.Click
+	ctx := c.Request.Context()
 	
 	// Get recent URLs
-	config.DB.Order("created_at desc").Limit(limit).Find(&recentURLs)
+	recentURLs, _ := store.Links.Recent(ctx, limit)
 	
-	// Get recent clicks with URL info
-	config.DB.Preload("URL").Order("created_at desc").Limit(limit).Find(&recentClicks)
+	// Get recent clicks
+	recentClicks, _ := store.Clicks.Recent(ctx, limit, clickFilter(c))
 	
 	activity := gin.H{
 		"recent_urls":   recentURLs,
@@ -157,13 +167,94 @@ func GetRecentActivity(c *gin.Context) {
 	c.JSON(http.StatusOK, activity)
 }
 
+// ListUsers returns every user account with its role
+func ListUsers(c *gin.Context) {
+	users, _ := store.Users.List(c.Request.Context())
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// UpdateUserRole changes the role of a user account
+func UpdateUserRole(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	// Admins cannot demote themselves and lock everyone out
+	if current := middleware.CurrentUser(c); current.ID == uint(id) && req.Role != models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change your own role"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	user, err := store.Users.FindByID(ctx, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := store.Users.UpdateRole(ctx, user, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// UpdateUserPlan puts a user account on another quota plan
+func UpdateUserPlan(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.UpdateUserPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if !middleware.IsValidPlan(req.Plan) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown plan"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	user, err := store.Users.FindByID(ctx, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := store.Users.UpdatePlan(ctx, user, req.Plan); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update plan"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
 // Utility functions
 func bytesToMB(b uint64) uint64 {
 	return b / 1024 / 1024
 }
 
 func checkDatabaseStatus() string {
-	if err := config.DB.Exec("SELECT 1").Error; err != nil {
+	if err := store.Ping(config.Ctx); err != nil {
 		return "down"
 	}
 	return "up"
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"shorter-backend/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appLinksMaxAge is how long the association files may be cached
+const appLinksMaxAge = 3600
+
+var (
+	// appleAppSiteAssociation lets iOS open this domain's links in an app
+	// (universal links)
+	appleAppSiteAssociation []byte
+	// androidAssetLinks lets Android open this domain's links in an app
+	// (App Links)
+	androidAssetLinks []byte
+)
+
+// LoadAppLinks reads the association files that make the short link domain
+// a deep-link domain, from the JSON files named by
+// APPLE_APP_SITE_ASSOCIATION_FILE and ANDROID_ASSET_LINKS_FILE. Files not
+// configured aren't served.
+func LoadAppLinks() error {
+	var err error
+	if appleAppSiteAssociation, err = readJSONFile(config.GetEnv("APPLE_APP_SITE_ASSOCIATION_FILE", "")); err != nil {
+		return err
+	}
+	if androidAssetLinks, err = readJSONFile(config.GetEnv("ANDROID_ASSET_LINKS_FILE", "")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readJSONFile returns the contents of the JSON file at path, or nothing
+// when path is empty
+func readJSONFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%s: not valid JSON", path)
+	}
+	return data, nil
+}
+
+// GetAppleAppSiteAssociation serves the apple-app-site-association file
+func GetAppleAppSiteAssociation(c *gin.Context) {
+	serveAppLinks(c, appleAppSiteAssociation)
+}
+
+// GetAndroidAssetLinks serves the assetlinks.json file
+func GetAndroidAssetLinks(c *gin.Context) {
+	serveAppLinks(c, androidAssetLinks)
+}
+
+// serveAppLinks answers with an association file as JSON, without
+// redirecting, as both platforms require
+func serveAppLinks(c *gin.Context, data []byte) {
+	if data == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", appLinksMaxAge))
+	c.Data(http.StatusOK, "application/json", data)
+}
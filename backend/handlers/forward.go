@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"shorter-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isValidForwardQuery reports whether mode is a query forwarding mode
+func isValidForwardQuery(mode string) bool {
+	switch mode {
+	case models.ForwardQueryNone, models.ForwardQueryPreserve, models.ForwardQueryOverride, models.ForwardQueryAppend:
+		return true
+	}
+	return false
+}
+
+// redirectTarget is where a visit goes and why
+type redirectTarget struct {
+	URL string
+	// Rule names the rule that chose the destination, if any
+	Rule string
+	// Variant names the split test variant the visitor is on, if any
+	Variant string
+}
+
+// forwardedPath returns the path after the short code that a visit
+// forwards, reporting false for a path the link doesn't accept
+func forwardedPath(c *gin.Context, url models.URL) (string, bool) {
+	rest := c.Param("rest")
+	if rest == "/" && !url.ForwardPath {
+		// A trailing slash alone isn't an extra path
+		rest = ""
+	}
+	if rest != "" && !url.ForwardPath {
+		return "", false
+	}
+	return rest, true
+}
+
+// destinationFor returns where a visit goes: the destination of the first
+// rule the visitor matches, else that of their split test variant, else the
+// link's own, with rest (see forwardedPath) and the query string forwarded
+// as the link allows. A variant is passed on in the variantParam parameter. Visitors are assigned a variant here, so it is only
+// called once the visit is redirected.
+func destinationFor(c *gin.Context, url models.URL, rest string) redirectTarget {
+	target := redirectTarget{URL: url.OriginalURL}
+	rule, matched := models.Rule{}, false
+	if len(url.Rules) > 0 {
+		rule, matched = matchRule(url.Rules, visitorOf(c))
+	}
+	switch {
+	case matched:
+		target = redirectTarget{URL: rule.URL, Rule: rule.Name}
+	case len(url.Variants) > 0:
+		variant := chooseVariant(c, url)
+		target = redirectTarget{URL: variant.URL, Variant: variant.Name}
+	}
+
+	forwardPath := rest != ""
+	forwardQuery := url.ForwardQuery != models.ForwardQueryNone && c.Request.URL.RawQuery != ""
+	if !forwardPath && !forwardQuery && target.Variant == "" {
+		return target
+	}
+
+	destination, err := neturl.Parse(target.URL)
+	if err != nil {
+		return target
+	}
+
+	if forwardPath {
+		// Cleaning keeps ../ from climbing above the destination's path
+		extra := path.Clean(rest)
+		if strings.HasSuffix(rest, "/") && extra != "/" {
+			extra += "/"
+		}
+		destination.Path = strings.TrimSuffix(destination.Path, "/") + extra
+		destination.RawPath = ""
+	}
+
+	if forwardQuery {
+		destination.RawQuery = mergeQuery(destination.Query(), c.Request.URL.Query(), url.ForwardQuery).Encode()
+	}
+
+	if target.Variant != "" {
+		// The variant cookie doesn't reach pages on other sites, so the
+		// destination is told its variant to report conversions with
+		if destination.RawQuery != "" {
+			destination.RawQuery += "&"
+		}
+		destination.RawQuery += variantParam + "=" + neturl.QueryEscape(target.Variant)
+	}
+
+	target.URL = destination.String()
+	return target
+}
+
+// mergeQuery adds the incoming parameters to the destination's, settling
+// parameters set on both as mode says
+func mergeQuery(destination, incoming neturl.Values, mode string) neturl.Values {
+	for key, values := range incoming {
+		switch mode {
+		case models.ForwardQueryPreserve:
+			if _, ok := destination[key]; !ok {
+				destination[key] = values
+			}
+		case models.ForwardQueryOverride:
+			destination[key] = values
+		case models.ForwardQueryAppend:
+			destination[key] = append(destination[key], values...)
+		}
+	}
+	return destination
+}
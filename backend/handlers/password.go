@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+
+	"shorter-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+var passwordFormTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Protected Link - {{.ShortCode}}</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="robots" content="noindex">
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, sans-serif; margin: 0; padding: 40px; background: #f8f9fa; }
+        .container { max-width: 400px; margin: 0 auto; background: white; padding: 40px; border-radius: 12px; box-shadow: 0 2px 20px rgba(0,0,0,0.1); text-align: center; }
+        input[type=password] { width: 100%; box-sizing: border-box; padding: 10px; border: 1px solid #ddd; border-radius: 6px; font-size: 16px; margin: 10px 0; }
+        button { background: #0066cc; color: white; padding: 10px 20px; border: none; border-radius: 6px; font-size: 16px; cursor: pointer; }
+        button:hover { background: #0052a3; }
+        .error { color: #cc0000; margin-bottom: 10px; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>Protected Link</h1>
+        <p>This link is password protected.</p>
+        {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+        <form method="POST" action="{{.Action}}">
+            <input type="password" name="password" placeholder="Password" autofocus required>
+            <button type="submit">Continue</button>
+        </form>
+    </div>
+</body>
+</html>`))
+
+// UnlockURL checks the submitted password for a protected short URL and
+// redirects on success
+func UnlockURL(c *gin.Context) {
+	shortCode := c.Param("code")
+	if shortCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code is required"})
+		return
+	}
+
+	url, err := lookupURL(c.Request.Context(), shortCode)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+
+	rest, ok := forwardedPath(c, url)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+
+	if url.IsExpired(time.Now()) {
+		respondGone(c)
+		return
+	}
+
+	// Unprotected links have nothing to unlock
+	if url.PasswordHash == "" {
+		completeRedirect(c, url, rest, http.StatusSeeOther)
+		return
+	}
+
+	if !utils.CheckPassword(url.PasswordHash, c.PostForm("password")) {
+		renderPasswordForm(c, http.StatusUnauthorized, shortCode, "Incorrect password")
+		return
+	}
+
+	// 303 makes the browser follow up with a GET on the destination
+	completeRedirect(c, url, rest, http.StatusSeeOther)
+}
+
+// renderPasswordForm writes the password prompt for a protected short URL
+func renderPasswordForm(c *gin.Context, status int, shortCode, message string) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	passwordFormTemplate.Execute(c.Writer, gin.H{
+		"ShortCode": shortCode,
+		// Post back to the same path and query so they reach the destination
+		"Action": c.Request.URL.RequestURI(),
+		"Error":  message,
+	})
+}
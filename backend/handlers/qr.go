@@ -3,8 +3,7 @@ package handlers
 import (
 	"net/http"
 
-	"shorter-backend/config"
-	"shorter-backend/models"
+	"shorter-backend/store"
 
 	"github.com/gin-gonic/gin"
 	"github.com/skip2/go-qrcode"
@@ -19,8 +18,7 @@ func GenerateQRCode(c *gin.Context) {
 	}
 
 	// Check if URL exists
-	var url models.URL
-	if err := config.DB.Where("short_code = ?", shortCode).First(&url).Error; err != nil {
+	if _, err := store.Links.FindByCode(c.Request.Context(), store.AnyOwner, shortCode); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
 		return
 	}
@@ -58,8 +56,8 @@ func GetQRCodeHTML(c *gin.Context) {
 	}
 
 	// Check if URL exists
-	var url models.URL
-	if err := config.DB.Where("short_code = ?", shortCode).First(&url).Error; err != nil {
+	url, err := store.Links.FindByCode(c.Request.Context(), store.AnyOwner, shortCode)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
 		return
 	}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"shorter-backend/analytics"
+	"shorter-backend/models"
+	"shorter-backend/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usePipeline gives a test an analytics pipeline that queues clicks without
+// writing them, so Stats tells how many were tracked
+func usePipeline(t *testing.T) *analytics.Pipeline {
+	t.Helper()
+	original := analytics.Default
+	analytics.Default = analytics.New(analytics.Config{})
+	t.Cleanup(func() { analytics.Default = original })
+	return analytics.Default
+}
+
+const browserUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+func TestRedirectClickBudget(t *testing.T) {
+	type visit struct {
+		method  string
+		ua      string
+		status  int
+		tracked bool
+	}
+	browser := visit{http.MethodGet, browserUA, http.StatusFound, true}
+	gone := visit{http.MethodGet, browserUA, http.StatusGone, false}
+
+	tests := []struct {
+		name   string
+		visits []visit
+	}{
+		{
+			name:   "browsers use up clicks",
+			visits: []visit{browser, gone},
+		},
+		{
+			name: "HEAD requests are neither counted nor tracked",
+			visits: []visit{
+				{http.MethodHead, browserUA, http.StatusFound, false},
+				{http.MethodHead, browserUA, http.StatusFound, false},
+				browser,
+				{http.MethodHead, browserUA, http.StatusGone, false},
+			},
+		},
+		{
+			name: "crawlers don't use up clicks",
+			visits: []visit{
+				{http.MethodGet, "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", http.StatusFound, true},
+				{http.MethodGet, "", http.StatusFound, true},
+				browser,
+				{http.MethodGet, "facebookexternalhit/1.1", http.StatusGone, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			pipeline := usePipeline(t)
+			url := &models.URL{OriginalURL: "https://example.com", ShortCode: "budget", MaxClicks: 1}
+			if err := store.Links.Create(context.Background(), url); err != nil {
+				t.Fatal(err)
+			}
+
+			router := gin.New()
+			router.GET("/:code", RedirectURL)
+			router.HEAD("/:code", RedirectURL)
+
+			var tracked uint64
+			for i, v := range tt.visits {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(v.method, "/budget", nil)
+				req.Header.Set("User-Agent", v.ua)
+				router.ServeHTTP(w, req)
+
+				if w.Code != v.status {
+					t.Errorf("visit %d: %s with %q = %d, want %d", i, v.method, v.ua, w.Code, v.status)
+				}
+				if v.tracked {
+					tracked++
+				}
+				if got := pipeline.Stats().Enqueued; got != tracked {
+					t.Errorf("visit %d: %d clicks tracked, want %d", i, got, tracked)
+				}
+			}
+		})
+	}
+}
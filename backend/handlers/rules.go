@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"strings"
+
+	"shorter-backend/geoip"
+	"shorter-backend/models"
+	"shorter-backend/useragent"
+	"shorter-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRules bounds the rules of a single link
+const maxRules = 20
+
+// maxRuleNameLength matches the size of models.Click.Rule
+const maxRuleNameLength = 64
+
+var (
+	countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	regionCodePattern  = regexp.MustCompile(`^[A-Z]{2}-[A-Z0-9]{1,3}$`)
+	languagePattern    = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+)
+
+// unsafeSchemes may never be redirected to, even by app link rules
+var unsafeSchemes = map[string]bool{"javascript": true, "data": true, "vbscript": true, "file": true, "blob": true}
+
+// visitor is what rules know about the client of a redirect
+type visitor struct {
+	Country  string
+	Region   string
+	Language string
+	Device   string
+	OS       string
+}
+
+// visitorOf describes the client of the request
+func visitorOf(c *gin.Context) visitor {
+	loc := geoip.Default.Lookup(utils.GetClientIP(c.Request))
+	agent := useragent.Parse(c.Request.UserAgent())
+	return visitor{
+		Country:  loc.Country,
+		Region:   loc.Region,
+		Language: utils.PreferredLanguage(c.GetHeader("Accept-Language")),
+		Device:   agent.Device,
+		OS:       agent.OS,
+	}
+}
+
+// matchRule returns the first of the link's rules the visitor matches
+func matchRule(rules models.Rules, v visitor) (models.Rule, bool) {
+	for _, rule := range rules {
+		if ruleMatches(rule, v) {
+			return rule, true
+		}
+	}
+	return models.Rule{}, false
+}
+
+// ruleMatches reports whether the visitor meets every condition of rule
+func ruleMatches(rule models.Rule, v visitor) bool {
+	if len(rule.Countries) > 0 && !containsString(rule.Countries, v.Country) {
+		return false
+	}
+	if len(rule.Regions) > 0 && !containsString(rule.Regions, v.Region) {
+		return false
+	}
+	if len(rule.Devices) > 0 && !containsString(rule.Devices, v.Device) {
+		return false
+	}
+	if len(rule.OS) > 0 && !containsString(rule.OS, v.OS) {
+		return false
+	}
+	if len(rule.Languages) > 0 {
+		matched := false
+		for _, languageRange := range rule.Languages {
+			if v.Language != "" && utils.LanguageMatches(languageRange, v.Language) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRules validates the rules of a link and returns them in the form
+// they are stored in: codes in canonical case, destinations normalized and
+// every rule named. The error is meant for the client.
+func normalizeRules(rules models.Rules) (models.Rules, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("A link can have at most %d rules", maxRules)
+	}
+
+	normalized := make(models.Rules, 0, len(rules))
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		rule.Name = strings.TrimSpace(rule.Name)
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if len(rule.Name) > maxRuleNameLength {
+			return nil, fmt.Errorf("Rule names can be at most %d characters", maxRuleNameLength)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("Rule name %q is used twice", rule.Name)
+		}
+		names[rule.Name] = true
+
+		var ok bool
+		if rule.URL, ok = normalizeRuleURL(rule.URL); !ok {
+			return nil, fmt.Errorf("Rule %q has an invalid URL", rule.Name)
+		}
+
+		var err error
+		if rule.Countries, err = normalizeCodes(rule.Countries, strings.ToUpper, countryCodePattern); err != nil {
+			return nil, fmt.Errorf("Rule %q has an invalid country code %s", rule.Name, err)
+		}
+		if rule.Regions, err = normalizeCodes(rule.Regions, strings.ToUpper, regionCodePattern); err != nil {
+			return nil, fmt.Errorf("Rule %q has an invalid region code %s", rule.Name, err)
+		}
+		if rule.Languages, err = normalizeCodes(rule.Languages, strings.ToLower, languagePattern); err != nil {
+			return nil, fmt.Errorf("Rule %q has an invalid language %s", rule.Name, err)
+		}
+		if rule.Devices, err = normalizeNames(rule.Devices, useragent.Devices); err != nil {
+			return nil, fmt.Errorf("Rule %q has an unknown device %s", rule.Name, err)
+		}
+		if rule.OS, err = normalizeNames(rule.OS, useragent.OperatingSystems); err != nil {
+			return nil, fmt.Errorf("Rule %q has an unknown operating system %s", rule.Name, err)
+		}
+		if len(rule.Countries) == 0 && len(rule.Regions) == 0 && len(rule.Languages) == 0 &&
+			len(rule.Devices) == 0 && len(rule.OS) == 0 {
+			return nil, fmt.Errorf("Rule %q needs at least one condition", rule.Name)
+		}
+
+		normalized = append(normalized, rule)
+	}
+
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
+
+// normalizeCodes converts codes to canonical case, failing with the first
+// one that doesn't match pattern
+func normalizeCodes(codes []string, canonical func(string) string, pattern *regexp.Regexp) ([]string, error) {
+	if len(codes) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		code = canonical(strings.TrimSpace(code))
+		if !pattern.MatchString(code) {
+			return nil, fmt.Errorf("%q", code)
+		}
+		normalized = append(normalized, code)
+	}
+	return normalized, nil
+}
+
+// normalizeNames replaces names with their spelling in known, ignoring
+// case, failing with the first one that isn't known
+func normalizeNames(names []string, known []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		found := false
+		for _, k := range known {
+			if strings.EqualFold(strings.TrimSpace(name), k) {
+				normalized = append(normalized, k)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%q", name)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeRuleURL checks the destination of a rule. Web addresses are
+// normalized like link destinations; other schemes open apps (intent://,
+// itms-apps://, market:// or an app's own) and are kept as they are.
+func normalizeRuleURL(rawURL string) (string, bool) {
+	rawURL = strings.TrimSpace(rawURL)
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil || parsed.Scheme == "" || parsed.Scheme == "http" || parsed.Scheme == "https" {
+		normalized := utils.NormalizeURL(rawURL)
+		return normalized, utils.IsValidURL(normalized)
+	}
+	if unsafeSchemes[strings.ToLower(parsed.Scheme)] || (parsed.Host == "" && parsed.Opaque == "" && parsed.Path == "") {
+		return "", false
+	}
+	return rawURL, true
+}
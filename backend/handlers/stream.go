@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"shorter-backend/middleware"
+	"shorter-backend/store"
+	"shorter-backend/stream"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamHeartbeat keeps idle event streams from being closed by proxies
+const streamHeartbeat = 15 * time.Second
+
+// StreamURLClicks pushes the clicks on one short URL as Server-Sent Events
+func StreamURLClicks(c *gin.Context) {
+	url, err := store.Links.FindByCode(c.Request.Context(), manageableBy(middleware.CurrentUser(c)), c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+
+	streamClicks(c, url.ID)
+}
+
+// StreamAllClicks pushes the clicks on every short URL as Server-Sent Events
+func StreamAllClicks(c *gin.Context) {
+	streamClicks(c, 0)
+}
+
+// streamClicks relays the broker's events for urlID (zero for all links)
+// until the client disconnects or the server shuts down
+func streamClicks(c *gin.Context, urlID uint) {
+	sub, err := stream.Default.Subscribe(urlID)
+	if errors.Is(err, stream.ErrTooManySubscribers) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Too many open streams, try again later"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+	defer sub.Close()
+
+	filter := clickFilter(c)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("ready", gin.H{"url_id": urlID})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return false
+			}
+			if event.IsBot && !filter.IncludeBots {
+				return true
+			}
+			c.SSEvent("click", event)
+			return true
+		case <-heartbeat.C:
+			// Comment lines are ignored by EventSource clients
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
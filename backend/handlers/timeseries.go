@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shorter-backend/config"
+	"shorter-backend/middleware"
+	"shorter-backend/models"
+	"shorter-backend/rollups"
+	"shorter-backend/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TimeseriesPoint is the click count of one bucket. Uniques is only set
+// when the bucket matches a stored rollup, since visitor counts of
+// separate buckets can't be added up.
+type TimeseriesPoint struct {
+	Bucket  time.Time `json:"bucket"`
+	Clicks  int64     `json:"clicks"`
+	Uniques *int64    `json:"uniques,omitempty"`
+}
+
+// TimeseriesResponse is a link's clicks over a time window
+type TimeseriesResponse struct {
+	From        time.Time                     `json:"from"`
+	To          time.Time                     `json:"to"`
+	Granularity string                        `json:"granularity"`
+	TimeZone    string                        `json:"timezone"`
+	TotalClicks int64                         `json:"total_clicks"`
+	Series      []TimeseriesPoint             `json:"series"`
+	Top         map[string][]store.ValueCount `json:"top"`
+}
+
+// maxSeriesBuckets bounds the size of a time-series response
+const maxSeriesBuckets = 2000
+
+// Default and maximum number of values reported per dimension
+const (
+	defaultTopN = 10
+	maxTopN     = 100
+)
+
+// defaultLookback is the window charted when no start is given
+var defaultLookback = map[string]func(time.Time) time.Time{
+	models.GranularityHour:  func(t time.Time) time.Time { return t.Add(-48 * time.Hour) },
+	models.GranularityDay:   func(t time.Time) time.Time { return t.AddDate(0, 0, -30) },
+	models.GranularityWeek:  func(t time.Time) time.Time { return t.AddDate(0, 0, -7*12) },
+	models.GranularityMonth: func(t time.Time) time.Time { return t.AddDate(-1, 0, 0) },
+}
+
+// bucketStart returns the start of the bucket containing t, in loc
+func bucketStart(t time.Time, granularity string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch granularity {
+	case models.GranularityHour:
+		// Subtract rather than rebuild the wall clock so repeated hours at
+		// the end of daylight saving time stay apart
+		return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	case models.GranularityWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		// Weeks start on Monday
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case models.GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// nextBucket returns the start of the bucket after the one starting at start
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case models.GranularityHour:
+		return start.Add(time.Hour)
+	case models.GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case models.GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// parseTime reads a range bound given as RFC 3339 or as a date in loc. A
+// date-only end bound includes that whole day.
+func parseTime(value string, loc *time.Location, end bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, true
+}
+
+// parseTopN reads the top query parameter
+func parseTopN(c *gin.Context) (int, bool) {
+	value := c.Query("top")
+	if value == "" {
+		return defaultTopN, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxTopN {
+		return 0, false
+	}
+	return n, true
+}
+
+// GetURLTimeseries returns a link's clicks bucketed by hour, day, week or
+// month in the requested time zone, with empty buckets filled in, and the
+// top values of the requested dimensions over the same window
+func GetURLTimeseries(c *gin.Context) {
+	granularity := c.DefaultQuery("granularity", models.GranularityDay)
+	lookback, ok := defaultLookback[granularity]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be hour, day, week or month"})
+		return
+	}
+
+	loc, err := time.LoadLocation(c.DefaultQuery("tz", config.GetEnv("STATS_TIMEZONE", "UTC")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown time zone"})
+		return
+	}
+
+	to := time.Now()
+	if value := c.Query("to"); value != "" {
+		if to, ok = parseTime(value, loc, true); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be RFC 3339 or YYYY-MM-DD"})
+			return
+		}
+	}
+	from := lookback(to)
+	if value := c.Query("from"); value != "" {
+		if from, ok = parseTime(value, loc, false); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be RFC 3339 or YYYY-MM-DD"})
+			return
+		}
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	topN, ok := parseTopN(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "top must be between 1 and 100"})
+		return
+	}
+
+	dimensions := rollups.Dimensions
+	if value := c.Query("dimensions"); value != "" {
+		dimensions = nil
+		for _, name := range strings.Split(value, ",") {
+			dimension := store.Dimension(strings.TrimSpace(name))
+			if !isRollupDimension(dimension) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown dimension " + string(dimension)})
+				return
+			}
+			dimensions = append(dimensions, dimension)
+		}
+	}
+
+	// Widen the window to whole buckets and lay them out
+	start := bucketStart(from, granularity, loc)
+	end := bucketStart(to, granularity, loc)
+	if end.Before(to) {
+		end = nextBucket(end, granularity)
+	}
+	var buckets []time.Time
+	for b := start; b.Before(end); b = nextBucket(b, granularity) {
+		if len(buckets) == maxSeriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Too many buckets, narrow the range or use a coarser granularity"})
+			return
+		}
+		buckets = append(buckets, b)
+	}
+
+	ctx := c.Request.Context()
+	url, err := store.Links.FindByCode(ctx, manageableBy(middleware.CurrentUser(c)), c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+
+	// Daily rollups line up with the buckets only in UTC; everything else is
+	// regrouped from hourly rollups. Zones offset by a fraction of an hour
+	// count each hourly rollup in the bucket its hour starts in.
+	source := models.GranularityHour
+	if granularity != models.GranularityHour && loc == time.UTC {
+		source = models.GranularityDay
+	}
+	// Unique visitors can't be added up across buckets
+	exactUniques := granularity == source
+
+	query := store.RollupQuery{
+		URLID:       url.ID,
+		Granularity: source,
+		From:        start,
+		To:          end,
+		Filter:      clickFilter(c),
+	}
+	rows, err := store.Rollups.Series(ctx, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load statistics"})
+		return
+	}
+
+	counts := make(map[int64]*store.BucketCount, len(buckets))
+	for _, row := range rows {
+		key := bucketStart(row.Bucket, granularity, loc).Unix()
+		bucket, ok := counts[key]
+		if !ok {
+			bucket = &store.BucketCount{}
+			counts[key] = bucket
+		}
+		bucket.Clicks += row.Clicks
+		bucket.Uniques += row.Uniques
+	}
+
+	var total int64
+	series := make([]TimeseriesPoint, 0, len(buckets))
+	for _, b := range buckets {
+		point := TimeseriesPoint{Bucket: b}
+		if bucket, ok := counts[b.Unix()]; ok {
+			point.Clicks = bucket.Clicks
+			if exactUniques {
+				uniques := bucket.Uniques
+				point.Uniques = &uniques
+			}
+		} else if exactUniques {
+			point.Uniques = new(int64)
+		}
+		total += point.Clicks
+		series = append(series, point)
+	}
+
+	top := make(map[string][]store.ValueCount, len(dimensions))
+	for _, dimension := range dimensions {
+		values, err := store.Rollups.TopValues(ctx, query, dimension, topN)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load statistics"})
+			return
+		}
+		top[string(dimension)] = values
+	}
+
+	c.JSON(http.StatusOK, TimeseriesResponse{
+		From:        start,
+		To:          end,
+		Granularity: granularity,
+		TimeZone:    loc.String(),
+		TotalClicks: total,
+		Series:      series,
+		Top:         top,
+	})
+}
+
+// isRollupDimension reports whether statistics are kept for dimension
+func isRollupDimension(dimension store.Dimension) bool {
+	for _, d := range rollups.Dimensions {
+		if d == dimension {
+			return true
+		}
+	}
+	return false
+}
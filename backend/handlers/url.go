@@ -1,19 +1,71 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
 	"time"
 
+	"shorter-backend/analytics"
 	"shorter-backend/config"
+	"shorter-backend/metadata"
+	"shorter-backend/middleware"
 	"shorter-backend/models"
+	"shorter-backend/rollups"
+	"shorter-backend/store"
+	"shorter-backend/uniques"
+	"shorter-backend/useragent"
 	"shorter-backend/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
+// defaultCacheTTL is how long a redirect stays cached when the link has
+// no earlier expiry
+const defaultCacheTTL = 24 * time.Hour
+
+// permanentRedirectMaxAge bounds how long browsers may remember a permanent
+// redirect, so a changed destination still reaches every visitor in time
+const permanentRedirectMaxAge = 24 * time.Hour
+
+// maxCodeAttempts bounds the random short codes tried for a new link
+const maxCodeAttempts = 5
+
+// errNoFreeCode is returned when every random short code tried was taken
+var errNoFreeCode = errors.New("no free short code found")
+
+// generateShortCode draws random short codes; tests replace it to force
+// collisions
+var generateShortCode = utils.GenerateShortCode
+
+// newShortCode picks a random short code that isn't taken yet
+func newShortCode(ctx context.Context) (string, error) {
+	for i := 0; i < maxCodeAttempts; i++ {
+		code := generateShortCode()
+		exists, err := store.Links.CodeExists(ctx, code)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return code, nil
+		}
+	}
+	return "", errNoFreeCode
+}
+
+// isValidRedirectStatus reports whether links may redirect with status
+func isValidRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
 // ShortenURL creates a new short URL
 func ShortenURL(c *gin.Context) {
 	var req models.CreateURLRequest
@@ -29,27 +81,63 @@ func ShortenURL(c *gin.Context) {
 		return
 	}
 
-	// Check if URL already exists
-	var existingURL models.URL
-	if err := config.DB.Where("original_url = ?", normalizedURL).First(&existingURL).Error; err == nil {
-		// URL already exists, return existing short code
-		baseURL := getBaseURL(c)
-		response := models.URLResponse{
-			ID:          existingURL.ID,
-			OriginalURL: existingURL.OriginalURL,
-			ShortCode:   existingURL.ShortCode,
-			ShortURL:    fmt.Sprintf("%s/%s", baseURL, existingURL.ShortCode),
-			Title:       existingURL.Title,
-			CreatedAt:   existingURL.CreatedAt,
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry date must be in the future"})
+		return
+	}
+	if req.MaxClicks < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Max clicks cannot be negative"})
+		return
+	}
+	redirectStatus := models.DefaultRedirectStatus
+	if req.RedirectStatus != 0 {
+		if !isValidRedirectStatus(req.RedirectStatus) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Redirect status must be 301, 302, 307 or 308"})
+			return
 		}
+		redirectStatus = req.RedirectStatus
+	}
+	if !isValidForwardQuery(req.ForwardQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Forward query must be preserve, override or append"})
+		return
+	}
+	rules, err := normalizeRules(req.Rules)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	variants, err := normalizeVariants(req.Variants)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		// Get click count
-		var clickCount int64
-		config.DB.Model(&models.Click{}).Where("url_id = ?", existingURL.ID).Count(&clickCount)
-		response.ClickCount = clickCount
+	// Hash the password up front so protected links are never stored in clear
+	var passwordHash string
+	if req.Password != "" {
+		hash, err := utils.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password"})
+			return
+		}
+		passwordHash = hash
+	}
 
-		c.JSON(http.StatusOK, response)
-		return
+	ctx := c.Request.Context()
+	owner := middleware.CurrentUser(c)
+
+	// Check if the caller already shortened this URL (links with limits, a
+	// password, forwarding, rules, variants or their own redirect status
+	// are never shared)
+	if req.ExpiresAt == nil && req.MaxClicks == 0 && passwordHash == "" && redirectStatus == models.DefaultRedirectStatus &&
+		req.ForwardQuery == models.ForwardQueryNone && !req.ForwardPath && len(rules) == 0 && len(variants) == 0 {
+		if existingURL, err := store.Links.FindReusable(ctx, ownedBy(owner), normalizedURL); err == nil {
+			// URL already exists, return existing short code
+			clickCount := countClicks(ctx, existingURL.ID, clickFilter(c))
+
+			c.JSON(http.StatusOK, toURLResponse(c, *existingURL, clickCount))
+			return
+		}
 	}
 
 	// Generate short code
@@ -62,54 +150,63 @@ func ShortenURL(c *gin.Context) {
 		}
 
 		// Check if custom code already exists
-		var existingCustom models.URL
-		if err := config.DB.Where("short_code = ?", req.CustomCode).First(&existingCustom).Error; err == nil {
+		exists, err := store.Links.CodeExists(ctx, req.CustomCode)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create short URL"})
+			return
+		}
+		if exists {
 			c.JSON(http.StatusConflict, gin.H{"error": "Custom code already exists"})
 			return
 		}
 		shortCode = req.CustomCode
 	} else {
 		// Generate random short code
-		for {
-			shortCode = utils.GenerateShortCode()
-			var existing models.URL
-			if err := config.DB.Where("short_code = ?", shortCode).First(&existing).Error; err != nil {
-				break // Short code is unique
-			}
+		shortCode, err = newShortCode(ctx)
+		if errors.Is(err, errNoFreeCode) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Could not find a free short code, please try again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create short URL"})
+			return
 		}
 	}
 
-	// Get title from URL (optional)
-	title := utils.GetTitleFromURL(normalizedURL)
-
 	// Create new URL entry
 	newURL := models.URL{
 		OriginalURL: normalizedURL,
 		ShortCode:   shortCode,
-		Title:       title,
+		ExpiresAt:   req.ExpiresAt,
+		MaxClicks:   req.MaxClicks,
+		PasswordHash: passwordHash,
+		RedirectStatus: redirectStatus,
+		ForwardQuery: req.ForwardQuery,
+		ForwardPath:  req.ForwardPath,
+		Rules:        rules,
+		Variants:     variants,
+	}
+	if owner != nil {
+		newURL.UserID = &owner.ID
 	}
 
-	if err := config.DB.Create(&newURL).Error; err != nil {
+	if err := store.Links.Create(ctx, &newURL); err != nil {
+		// Another request may have taken the code since it was checked
+		if errors.Is(err, store.ErrDuplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Short code already exists"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create short URL"})
 		return
 	}
 
 	// Cache the short URL
-	config.CacheSet(shortCode, normalizedURL, 24*time.Hour)
+	cacheURL(newURL)
 
-	// Prepare response
-	baseURL := getBaseURL(c)
-	response := models.URLResponse{
-		ID:          newURL.ID,
-		OriginalURL: newURL.OriginalURL,
-		ShortCode:   newURL.ShortCode,
-		ShortURL:    fmt.Sprintf("%s/%s", baseURL, newURL.ShortCode),
-		Title:       newURL.Title,
-		ClickCount:  0,
-		CreatedAt:   newURL.CreatedAt,
-	}
-
-	c.JSON(http.StatusCreated, response)
+	// Fill in the title and preview of the destination in the background
+	metadata.Default.Enqueue(newURL.ID, newURL.OriginalURL)
+
+	c.JSON(http.StatusCreated, toURLResponse(c, newURL, 0))
 }
 
 // RedirectURL handles the redirect from short URL to original URL
@@ -120,37 +217,99 @@ func RedirectURL(c *gin.Context) {
 		return
 	}
 
-	var originalURL string
-	var urlID uint
+	url, err := lookupURL(c.Request.Context(), shortCode)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
 
-	// Try to get from cache first
-	cachedURL, err := config.CacheGet(shortCode)
-	if err == nil && cachedURL != "" {
-		originalURL = cachedURL
-		// Get URL ID from database for click tracking
-		var url models.URL
-		if err := config.DB.Where("short_code = ?", shortCode).First(&url).Error; err == nil {
-			urlID = url.ID
+	rest, ok := forwardedPath(c, url)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+
+	if url.IsExpired(time.Now()) {
+		respondGone(c)
+		return
+	}
+
+	// Protected links show a password form instead of redirecting
+	if url.PasswordHash != "" {
+		renderPasswordForm(c, http.StatusOK, shortCode, "")
+		return
+	}
+
+	completeRedirect(c, url, rest, url.RedirectCode())
+}
+
+// completeRedirect enforces the click budget, records the click and sends
+// the visitor on to the link's destination for them, forwarding rest
+func completeRedirect(c *gin.Context, url models.URL, rest string, status int) {
+	if url.IsExpired(time.Now()) {
+		respondGone(c)
+		return
+	}
+
+	// Consume one click from the budget atomically so concurrent hits
+	// cannot overshoot max_clicks. HEAD requests and crawlers fetching a
+	// preview only check that clicks are left.
+	if url.MaxClicks > 0 {
+		var ok bool
+		var err error
+		if countsAsVisit(c.Request) {
+			ok, err = store.Links.ConsumeClick(c.Request.Context(), url.ID)
+		} else {
+			ok, err = hasClicksLeft(c.Request.Context(), url)
 		}
-	} else {
-		// Get from database
-		var url models.URL
-		if err := config.DB.Where("short_code = ?", shortCode).First(&url).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		if err != nil || !ok {
+			respondGone(c)
 			return
 		}
-		originalURL = url.OriginalURL
-		urlID = url.ID
-
-		// Cache for future requests
-		config.CacheSet(shortCode, originalURL, 24*time.Hour)
 	}
 
-	// Track click asynchronously
-	go trackClick(urlID, c.Request)
+	target := destinationFor(c, url, rest)
+
+	// Track click asynchronously; a HEAD request never reaches the
+	// destination, so it isn't a click
+	if c.Request.Method != http.MethodHead {
+		trackClick(url.ID, c.Request, target)
+	}
 
 	// Redirect to original URL
-	c.Redirect(http.StatusMovedPermanently, originalURL)
+	setRedirectCaching(c, url, status)
+	c.Redirect(status, target.URL)
+}
+
+// countsAsVisit reports whether a request uses up a click: anything but a
+// HEAD request from a client that doesn't identify as a bot
+func countsAsVisit(r *http.Request) bool {
+	ua := r.UserAgent()
+	return r.Method != http.MethodHead && ua != "" && !useragent.IsBot(ua)
+}
+
+// hasClicksLeft reports whether a link's click budget is not used up yet.
+// Cached links don't carry the clicks used, so they are read from the store.
+func hasClicksLeft(ctx context.Context, url models.URL) (bool, error) {
+	stored, err := store.Links.FindByCode(ctx, store.AnyOwner, url.ShortCode)
+	if err != nil {
+		return false, err
+	}
+	return stored.UsedClicks < stored.MaxClicks, nil
+}
+
+// setRedirectCaching tells browsers and proxies whether they may remember a
+// redirect. Temporary redirects are never stored, so every visit comes back
+// here to be counted. Permanent ones are kept for a limited time, unless an
+// expiry or click budget still has to be enforced on each visit or rules
+// or variants make the destination depend on the visitor.
+func setRedirectCaching(c *gin.Context, url models.URL, status int) {
+	permanent := status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+	if !permanent || url.ExpiresAt != nil || url.MaxClicks > 0 || len(url.Rules) > 0 || len(url.Variants) > 0 {
+		c.Header("Cache-Control", "no-store")
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(permanentRedirectMaxAge.Seconds())))
 }
 
 // GetURLStats returns statistics for a short URL
@@ -161,60 +320,102 @@ func GetURLStats(c *gin.Context) {
 		return
 	}
 
-	// Get URL from database
-	var url models.URL
-	if err := config.DB.Where("short_code = ?", shortCode).First(&url).Error; err != nil {
+	ctx := c.Request.Context()
+
+	// Get URL from database, only if the caller may see it
+	url, err := store.Links.FindByCode(ctx, manageableBy(middleware.CurrentUser(c)), shortCode)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
 		return
 	}
 
+	topN, ok := parseTopN(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "top must be between 1 and 100"})
+		return
+	}
+
+	// Bots are left out unless include_bots is set
+	filter := clickFilter(c)
+	allTime := store.RollupQuery{URLID: url.ID, Granularity: models.GranularityDay, Filter: filter}
+
 	// Get total clicks
-	var totalClicks int64
-	config.DB.Model(&models.Click{}).Where("url_id = ?", url.ID).Count(&totalClicks)
-
-	// Get daily clicks (last 30 days)
-	var dailyClicks []models.DailyClickStat
-	config.DB.Raw(`
-		SELECT DATE(created_at) as date, COUNT(*) as count 
-		FROM clicks 
-		WHERE url_id = ? AND created_at >= NOW() - INTERVAL '30 days'
-		GROUP BY DATE(created_at) 
-		ORDER BY date DESC
-	`, url.ID).Scan(&dailyClicks)
-
-	// Get country clicks (top 10)
-	var countryClicks []models.CountryClickStat
-	config.DB.Raw(`
-		SELECT country, COUNT(*) as count 
-		FROM clicks 
-		WHERE url_id = ? AND country != '' 
-		GROUP BY country 
-		ORDER BY count DESC 
-		LIMIT 10
-	`, url.ID).Scan(&countryClicks)
-
-	// Get referer clicks (top 10)
-	var refererClicks []models.RefererClickStat
-	config.DB.Raw(`
-		SELECT referer, COUNT(*) as count 
-		FROM clicks 
-		WHERE url_id = ? AND referer != '' 
-		GROUP BY referer 
-		ORDER BY count DESC 
-		LIMIT 10
-	`, url.ID).Scan(&refererClicks)
+	totalClicks := countClicks(ctx, url.ID, filter)
+
+	// Get unique visitors (estimated, human traffic only)
+	uniqueClicks, _ := uniques.Default.Count(ctx, url.ID)
+
+	// Get daily clicks (last 30 days), newest first
+	lastMonth := allTime
+	lastMonth.From = rollups.Truncate(time.Now().AddDate(0, 0, -29), models.GranularityDay)
+	days, _ := store.Rollups.Series(ctx, lastMonth)
+	dailyClicks := make([]models.DailyClickStat, 0, len(days))
+	for i := len(days) - 1; i >= 0; i-- {
+		dailyClicks = append(dailyClicks, models.DailyClickStat{
+			Date:    days[i].Bucket.Format("2006-01-02"),
+			Count:   days[i].Clicks,
+			Uniques: days[i].Uniques,
+		})
+	}
+
+	// Get country clicks (top N)
+	countries, _ := store.Rollups.TopValues(ctx, allTime, store.DimensionCountry, topN)
+	countryClicks := make([]models.CountryClickStat, 0, len(countries))
+	for _, v := range countries {
+		countryClicks = append(countryClicks, models.CountryClickStat{Country: v.Value, Count: v.Count})
+	}
+
+	// Get referer clicks (top N)
+	referers, _ := store.Rollups.TopValues(ctx, allTime, store.DimensionReferer, topN)
+	refererClicks := make([]models.RefererClickStat, 0, len(referers))
+	for _, v := range referers {
+		refererClicks = append(refererClicks, models.RefererClickStat{Referer: v.Value, Count: v.Count})
+	}
+
+	// Get device, OS and browser breakdowns (top N)
+	devices, _ := store.Rollups.TopValues(ctx, allTime, store.DimensionDevice, topN)
+	deviceClicks := make([]models.DeviceClickStat, 0, len(devices))
+	for _, v := range devices {
+		deviceClicks = append(deviceClicks, models.DeviceClickStat{Device: v.Value, Count: v.Count})
+	}
+
+	systems, _ := store.Rollups.TopValues(ctx, allTime, store.DimensionOS, topN)
+	osClicks := make([]models.OSClickStat, 0, len(systems))
+	for _, v := range systems {
+		osClicks = append(osClicks, models.OSClickStat{OS: v.Value, Count: v.Count})
+	}
+
+	browsers, _ := store.Rollups.TopValues(ctx, allTime, store.DimensionBrowser, topN)
+	browserClicks := make([]models.BrowserClickStat, 0, len(browsers))
+	for _, v := range browsers {
+		browserClicks = append(browserClicks, models.BrowserClickStat{Browser: v.Value, Count: v.Count})
+	}
+
+	// Split tests are broken down per variant, with their conversions
+	var variantClicks []models.VariantClickStat
+	if len(url.Variants) > 0 {
+		variants, _ := store.Rollups.TopValues(ctx, allTime, store.DimensionVariant, maxVariantStats)
+		visitors, _ := store.Clicks.VisitorsByVariant(ctx, url.ID)
+		conversions, _ := store.Conversions.CountByVariant(ctx, url.ID)
+		variantClicks = variantStats(variants, visitors, conversions, url.Variants)
+	}
 
 	response := models.ClickStatsResponse{
 		TotalClicks:    totalClicks,
+		UniqueClicks:   uniqueClicks,
 		DailyClicks:    dailyClicks,
 		CountryClicks:  countryClicks,
 		RefererClicks:  refererClicks,
+		DeviceClicks:   deviceClicks,
+		OSClicks:       osClicks,
+		BrowserClicks:  browserClicks,
+		VariantClicks:  variantClicks,
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
-// GetAllURLs returns all URLs created (with pagination)
+// GetAllURLs returns the URLs created by the caller (with pagination)
 func GetAllURLs(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
@@ -228,34 +429,24 @@ func GetAllURLs(c *gin.Context) {
 
 	offset := (page - 1) * limit
 
-	var urls []models.URL
-	var total int64
-
-	// Get total count
-	config.DB.Model(&models.URL{}).Count(&total)
+	ctx := c.Request.Context()
 
-	// Get URLs with pagination
-	config.DB.Limit(limit).Offset(offset).Order("created_at desc").Find(&urls)
+	// Get URLs with pagination and the total count
+	urls, total, err := store.Links.List(ctx, ownedBy(middleware.CurrentUser(c)), offset, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list short URLs"})
+		return
+	}
 
 	// Prepare response
-	baseURL := getBaseURL(c)
 	var responses []models.URLResponse
+	filter := clickFilter(c)
 	
 	for _, url := range urls {
 		// Get click count for each URL
-		var clickCount int64
-		config.DB.Model(&models.Click{}).Where("url_id = ?", url.ID).Count(&clickCount)
+		clickCount := countClicks(ctx, url.ID, filter)
 		
-		response := models.URLResponse{
-			ID:          url.ID,
-			OriginalURL: url.OriginalURL,
-			ShortCode:   url.ShortCode,
-			ShortURL:    fmt.Sprintf("%s/%s", baseURL, url.ShortCode),
-			Title:       url.Title,
-			ClickCount:  clickCount,
-			CreatedAt:   url.CreatedAt,
-		}
-		responses = append(responses, response)
+		responses = append(responses, toURLResponse(c, url, clickCount))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -267,23 +458,309 @@ func GetAllURLs(c *gin.Context) {
 	})
 }
 
-// trackClick records a click for analytics
-func trackClick(urlID uint, r *http.Request) {
+// UpdateURL changes the destination or title of an existing short URL
+func UpdateURL(c *gin.Context) {
+	shortCode := c.Param("code")
+	if shortCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code is required"})
+		return
+	}
+
+	var req models.UpdateURLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	url, err := store.Links.FindByCode(ctx, manageableBy(middleware.CurrentUser(c)), shortCode)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+
+	if req.URL != nil {
+		normalizedURL := utils.NormalizeURL(*req.URL)
+		if !utils.IsValidURL(normalizedURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid URL format"})
+			return
+		}
+		url.OriginalURL = normalizedURL
+	}
+	if req.Title != nil {
+		url.Title = *req.Title
+	}
+	if req.ExpiresAt.Set {
+		// null removes the expiry
+		if req.ExpiresAt.Time != nil && !req.ExpiresAt.Time.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry date must be in the future"})
+			return
+		}
+		url.ExpiresAt = req.ExpiresAt.Time
+	}
+	if req.MaxClicks != nil {
+		if *req.MaxClicks < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Max clicks cannot be negative"})
+			return
+		}
+		url.MaxClicks = *req.MaxClicks
+	}
+	if req.RedirectStatus != nil {
+		if !isValidRedirectStatus(*req.RedirectStatus) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Redirect status must be 301, 302, 307 or 308"})
+			return
+		}
+		url.RedirectStatus = *req.RedirectStatus
+	}
+	if req.ForwardQuery != nil {
+		if !isValidForwardQuery(*req.ForwardQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Forward query must be preserve, override or append"})
+			return
+		}
+		url.ForwardQuery = *req.ForwardQuery
+	}
+	if req.ForwardPath != nil {
+		url.ForwardPath = *req.ForwardPath
+	}
+	if req.Rules != nil {
+		// An empty list removes the rules
+		rules, err := normalizeRules(*req.Rules)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		url.Rules = rules
+	}
+	if req.Variants != nil {
+		// An empty list ends the split test
+		variants, err := normalizeVariants(*req.Variants)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		url.Variants = variants
+	}
+	if req.Password != nil {
+		// An empty password removes the protection
+		url.PasswordHash = ""
+		if *req.Password != "" {
+			hash, err := utils.HashPassword(*req.Password)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid password"})
+				return
+			}
+			url.PasswordHash = hash
+		}
+	}
+
+	if err := store.Links.Update(ctx, url); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update short URL"})
+		return
+	}
+
+	// Drop the cached destination so redirects pick up the change
+	config.CacheDelete(shortCode)
+
+	// A new destination has a new title and preview
+	if req.URL != nil {
+		metadata.Default.Enqueue(url.ID, url.OriginalURL)
+	}
+
+	clickCount := countClicks(ctx, url.ID, clickFilter(c))
+
+	c.JSON(http.StatusOK, toURLResponse(c, *url, clickCount))
+}
+
+// DeleteURL soft-deletes a short URL so it no longer redirects
+func DeleteURL(c *gin.Context) {
+	shortCode := c.Param("code")
+	if shortCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code is required"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	url, err := store.Links.FindByCode(ctx, manageableBy(middleware.CurrentUser(c)), shortCode)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+
+	if err := store.Links.Delete(ctx, url); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete short URL"})
+		return
+	}
+
+	config.CacheDelete(shortCode)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Short URL deleted"})
+}
+
+// trackClick queues a click for analytics. The request is read here, on
+// the handler goroutine, because it must not be used after the handler
+// returns.
+func trackClick(urlID uint, r *http.Request, target redirectTarget) {
 	if urlID == 0 {
 		return
 	}
 
-	click := models.Click{
-		URLId:     urlID,
-		IPAddress: utils.GetClientIP(r),
-		UserAgent: r.UserAgent(),
-		Referer:   r.Referer(),
-		// You can enhance this with IP geolocation service
-		Country:   "", // Get from IP geolocation service
-		City:      "", // Get from IP geolocation service
+	analytics.Default.Enqueue(&analytics.Hit{
+		Click: models.Click{
+			URLId:     urlID,
+			IPAddress: utils.GetClientIP(r),
+			UserAgent: r.UserAgent(),
+			Referer:   r.Referer(),
+			Rule:      target.Rule,
+			Variant:   target.Variant,
+			CreatedAt: time.Now(),
+		},
+		Method: r.Method,
+		Header: r.Header.Clone(),
+	})
+}
+
+// clickFilter reads the click filter of a stats request. Bot clicks are
+// excluded unless the include_bots query parameter is true.
+func clickFilter(c *gin.Context) store.ClickFilter {
+	includeBots, _ := strconv.ParseBool(c.Query("include_bots"))
+	return store.ClickFilter{IncludeBots: includeBots}
+}
+
+// countClicks totals a link's clicks from the daily rollups
+func countClicks(ctx context.Context, urlID uint, filter store.ClickFilter) int64 {
+	total, _ := store.Rollups.Sum(ctx, store.RollupQuery{URLID: urlID, Granularity: models.GranularityDay, Filter: filter})
+	return total
+}
+
+// ownedBy scopes link lookups to the given user, or to anonymous links
+// when user is nil
+func ownedBy(user *models.User) store.Scope {
+	if user == nil {
+		return store.Scope{}
+	}
+	return store.Scope{UserID: &user.ID}
+}
+
+// manageableBy scopes link lookups to the links the user may change: their
+// own, or any link for admins
+func manageableBy(user *models.User) store.Scope {
+	if user != nil && user.HasRole(models.RoleAdmin) {
+		return store.AnyOwner
+	}
+	return ownedBy(user)
+}
+
+// cachedURL is the subset of a URL stored in Redis for redirects
+type cachedURL struct {
+	ID          uint       `json:"id"`
+	OriginalURL string     `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxClicks   int64      `json:"max_clicks,omitempty"`
+	// PasswordHash never leaves the server; Redis is an internal cache
+	PasswordHash string `json:"password_hash,omitempty"`
+	RedirectStatus int  `json:"redirect_status,omitempty"`
+	ForwardQuery string `json:"forward_query,omitempty"`
+	ForwardPath  bool   `json:"forward_path,omitempty"`
+	Rules        models.Rules `json:"rules,omitempty"`
+	Variants     models.Variants `json:"variants,omitempty"`
+}
+
+// lookupURL finds a URL by short code, trying the cache before the database
+func lookupURL(ctx context.Context, shortCode string) (models.URL, error) {
+	if cached, err := config.CacheGet(shortCode); err == nil {
+		var entry cachedURL
+		if json.Unmarshal([]byte(cached), &entry) == nil && entry.ID != 0 {
+			return models.URL{
+				ID:          entry.ID,
+				ShortCode:   shortCode,
+				OriginalURL: entry.OriginalURL,
+				ExpiresAt:   entry.ExpiresAt,
+				MaxClicks:   entry.MaxClicks,
+				PasswordHash: entry.PasswordHash,
+				RedirectStatus: entry.RedirectStatus,
+				ForwardQuery: entry.ForwardQuery,
+				ForwardPath:  entry.ForwardPath,
+				Rules:        entry.Rules,
+				Variants:     entry.Variants,
+			}, nil
+		}
+	}
+
+	url, err := store.Links.FindByCode(ctx, store.AnyOwner, shortCode)
+	if err != nil {
+		return models.URL{}, err
+	}
+
+	// Cache for future requests
+	cacheURL(*url)
+	return *url, nil
+}
+
+// cacheURL stores the redirect data for a URL, never outliving its expiry
+func cacheURL(url models.URL) {
+	ttl := defaultCacheTTL
+	if url.ExpiresAt != nil {
+		remaining := time.Until(*url.ExpiresAt)
+		if remaining <= 0 {
+			return
+		}
+		if remaining < ttl {
+			ttl = remaining
+		}
+	}
+
+	data, err := json.Marshal(cachedURL{
+		ID:          url.ID,
+		OriginalURL: url.OriginalURL,
+		ExpiresAt:   url.ExpiresAt,
+		MaxClicks:   url.MaxClicks,
+		PasswordHash: url.PasswordHash,
+		RedirectStatus: url.RedirectStatus,
+		ForwardQuery: url.ForwardQuery,
+		ForwardPath:  url.ForwardPath,
+		Rules:        url.Rules,
+		Variants:     url.Variants,
+	})
+	if err != nil {
+		return
+	}
+	config.CacheSet(url.ShortCode, string(data), ttl)
+}
+
+// respondGone answers a request for an expired or exhausted link, either
+// by redirecting to the configured fallback or with 410 Gone
+func respondGone(c *gin.Context) {
+	if fallback := os.Getenv("EXPIRED_URL_FALLBACK"); fallback != "" {
+		c.Redirect(http.StatusFound, fallback)
+		return
 	}
+	c.JSON(http.StatusGone, gin.H{"error": "Short URL is no longer available"})
+}
 
-	config.DB.Create(&click)
+// toURLResponse converts a URL model into its API representation
+func toURLResponse(c *gin.Context, url models.URL, clickCount int64) models.URLResponse {
+	return models.URLResponse{
+		ID:          url.ID,
+		OriginalURL: url.OriginalURL,
+		ShortCode:   url.ShortCode,
+		ShortURL:    fmt.Sprintf("%s/%s", getBaseURL(c), url.ShortCode),
+		Title:       url.Title,
+		Description: url.Description,
+		ImageURL:    url.ImageURL,
+		SiteName:    url.SiteName,
+		ClickCount:  clickCount,
+		ExpiresAt:   url.ExpiresAt,
+		MaxClicks:   url.MaxClicks,
+		PasswordProtected: url.PasswordHash != "",
+		RedirectStatus: url.RedirectCode(),
+		ForwardQuery: url.ForwardQuery,
+		ForwardPath:  url.ForwardPath,
+		Rules:        url.Rules,
+		Variants:     url.Variants,
+		CreatedAt:   url.CreatedAt,
+	}
 }
 
 // getBaseURL returns the base URL for short links
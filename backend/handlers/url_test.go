@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shorter-backend/models"
+	"shorter-backend/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// useMemoryStore gives a test an empty in-memory store
+func useMemoryStore(t *testing.T) {
+	t.Helper()
+	store.Use(store.NewMemoryBackend())
+}
+
+// fixedCodes makes generateShortCode return codes in turn, counting draws
+func fixedCodes(t *testing.T, codes ...string) *int {
+	t.Helper()
+	draws := 0
+	original := generateShortCode
+	generateShortCode = func() string {
+		code := codes[draws%len(codes)]
+		draws++
+		return code
+	}
+	t.Cleanup(func() { generateShortCode = original })
+	return &draws
+}
+
+// failingLinks is a link store whose code lookups fail
+type failingLinks struct {
+	store.LinkStore
+}
+
+func (failingLinks) CodeExists(ctx context.Context, code string) (bool, error) {
+	return false, errors.New("database is down")
+}
+
+func TestNewShortCode(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		taken     []string
+		deleted   []string
+		codes     []string
+		want      string
+		wantErr   error
+		wantDraws int
+	}{
+		{name: "free code", codes: []string{"aaaaaa"}, want: "aaaaaa", wantDraws: 1},
+		{name: "collision then free", taken: []string{"aaaaaa"}, codes: []string{"aaaaaa", "bbbbbb"}, want: "bbbbbb", wantDraws: 2},
+		{name: "deleted codes stay taken", deleted: []string{"cccccc"}, codes: []string{"cccccc", "dddddd"}, want: "dddddd", wantDraws: 2},
+		{name: "every code taken", taken: []string{"aaaaaa"}, codes: []string{"aaaaaa"}, wantErr: errNoFreeCode, wantDraws: maxCodeAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			for _, code := range tt.taken {
+				if err := store.Links.Create(ctx, &models.URL{OriginalURL: "https://example.com", ShortCode: code}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, code := range tt.deleted {
+				url := &models.URL{OriginalURL: "https://example.com", ShortCode: code}
+				if err := store.Links.Create(ctx, url); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Links.Delete(ctx, url); err != nil {
+					t.Fatal(err)
+				}
+			}
+			draws := fixedCodes(t, tt.codes...)
+
+			got, err := newShortCode(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("newShortCode() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("newShortCode() = %q, want %q", got, tt.want)
+			}
+			if *draws != tt.wantDraws {
+				t.Errorf("newShortCode() drew %d codes, want %d", *draws, tt.wantDraws)
+			}
+		})
+	}
+}
+
+func TestNewShortCodeStoreError(t *testing.T) {
+	useMemoryStore(t)
+	store.Links = failingLinks{store.Links}
+	draws := fixedCodes(t, "aaaaaa")
+
+	if _, err := newShortCode(context.Background()); err == nil || errors.Is(err, errNoFreeCode) {
+		t.Fatalf("newShortCode() error = %v, want the store's error", err)
+	}
+	if *draws != 1 {
+		t.Errorf("newShortCode() drew %d codes after a store error, want 1", *draws)
+	}
+}
+
+func TestShortenURLCodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T)
+		body   string
+		status int
+	}{
+		{
+			name:   "random code",
+			body:   `{"url": "https://example.com"}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:   "custom code",
+			body:   `{"url": "https://example.com", "custom_code": "my-link"}`,
+			status: http.StatusCreated,
+		},
+		{
+			name: "custom code taken",
+			setup: func(t *testing.T) {
+				store.Links.Create(context.Background(), &models.URL{OriginalURL: "https://example.org", ShortCode: "my-link"})
+			},
+			body:   `{"url": "https://example.com", "custom_code": "my-link"}`,
+			status: http.StatusConflict,
+		},
+		{
+			name: "custom code of a deleted link",
+			setup: func(t *testing.T) {
+				url := &models.URL{OriginalURL: "https://example.org", ShortCode: "my-link"}
+				store.Links.Create(context.Background(), url)
+				store.Links.Delete(context.Background(), url)
+			},
+			body:   `{"url": "https://example.com", "custom_code": "my-link"}`,
+			status: http.StatusConflict,
+		},
+		{
+			name: "no free random code",
+			setup: func(t *testing.T) {
+				store.Links.Create(context.Background(), &models.URL{OriginalURL: "https://example.org", ShortCode: "aaaaaa"})
+				fixedCodes(t, "aaaaaa")
+			},
+			body:   `{"url": "https://example.com"}`,
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "store error on a random code",
+			setup: func(t *testing.T) {
+				store.Links = failingLinks{store.Links}
+			},
+			body:   `{"url": "https://example.com"}`,
+			status: http.StatusInternalServerError,
+		},
+		{
+			name: "store error on a custom code",
+			setup: func(t *testing.T) {
+				store.Links = failingLinks{store.Links}
+			},
+			body:   `{"url": "https://example.com", "custom_code": "my-link"}`,
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+
+			router := gin.New()
+			router.POST("/api/shorten", ShortenURL)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("POST /api/shorten = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+		})
+	}
+}
+
+func TestUpdateURLExpiry(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	later := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	past := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   *time.Time
+	}{
+		{name: "missing keeps the expiry", body: `{"title": "Renamed"}`, status: http.StatusOK, want: &expiry},
+		{name: "null removes the expiry", body: `{"expires_at": null}`, status: http.StatusOK},
+		{name: "future date moves the expiry", body: `{"expires_at": "` + later.Format(time.RFC3339) + `"}`, status: http.StatusOK, want: &later},
+		{name: "past date is rejected", body: `{"expires_at": "` + past.Format(time.RFC3339) + `"}`, status: http.StatusBadRequest, want: &expiry},
+		{name: "invalid date is rejected", body: `{"expires_at": "tomorrow"}`, status: http.StatusBadRequest, want: &expiry},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			link := &models.URL{OriginalURL: "https://example.com", ShortCode: "expiring", ExpiresAt: &expiry}
+			if err := store.Links.Create(context.Background(), link); err != nil {
+				t.Fatal(err)
+			}
+
+			router := gin.New()
+			router.PATCH("/api/urls/:code", UpdateURL)
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPatch, "/api/urls/expiring", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("PATCH %s = %d %s, want %d", tt.body, w.Code, w.Body, tt.status)
+			}
+			stored, err := store.Links.FindByCode(context.Background(), store.AnyOwner, "expiring")
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want == nil && stored.ExpiresAt != nil:
+				t.Errorf("expires_at = %v, want none", *stored.ExpiresAt)
+			case tt.want != nil && (stored.ExpiresAt == nil || !stored.ExpiresAt.Equal(*tt.want)):
+				t.Errorf("expires_at = %v, want %v", stored.ExpiresAt, *tt.want)
+			}
+		})
+	}
+}
+
+func TestShortenURLReusesOldestLink(t *testing.T) {
+	useMemoryStore(t)
+	for _, code := range []string{"first", "second", "third"} {
+		if err := store.Links.Create(context.Background(), &models.URL{OriginalURL: "https://example.com", ShortCode: code}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := gin.New()
+	router.POST("/api/shorten", ShortenURL)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"short_code":"first"`) {
+		t.Errorf("POST /api/shorten = %d %s, want the first link", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shorter-backend/middleware"
+	"shorter-backend/models"
+	"shorter-backend/store"
+	"shorter-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterUser creates a user account together with a first API key that
+// carries every scope
+func RegisterUser(c *gin.Context) {
+	var req models.RegisterUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	if _, err := store.Users.FindByEmail(ctx, email); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+		return
+	}
+
+	user := models.User{
+		Email: email,
+		Name:  req.Name,
+		Role:  models.RoleEditor,
+	}
+	if err := store.Users.Create(ctx, &user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	apiKey, key, err := issueAPIKey(ctx, user.ID, "default", models.AllScopes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"user":    user,
+		"api_key": toAPIKeyResponse(apiKey, key),
+	})
+}
+
+// GetCurrentUser returns the account the API key belongs to
+func GetCurrentUser(c *gin.Context) {
+	c.JSON(http.StatusOK, middleware.CurrentUser(c))
+}
+
+// GetUsage returns the caller's quota plan and how much of it is used
+func GetUsage(c *gin.Context) {
+	c.JSON(http.StatusOK, middleware.Usage(c))
+}
+
+// ListAPIKeys returns the caller's API keys, including revoked ones
+func ListAPIKeys(c *gin.Context) {
+	user := middleware.CurrentUser(c)
+
+	keys, _ := store.Users.ListAPIKeys(c.Request.Context(), user.ID)
+
+	responses := make([]models.APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		responses = append(responses, toAPIKeyResponse(k, ""))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": responses})
+}
+
+// CreateAPIKey issues a new API key for the caller
+func CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{models.ScopeLinksRead, models.ScopeLinksWrite, models.ScopeStatsRead}
+	}
+	for _, scope := range scopes {
+		if !utils.Contains(models.AllScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope})
+			return
+		}
+	}
+
+	// A key can never grant more than the key that created it
+	current := middleware.CurrentAPIKey(c)
+	for _, scope := range scopes {
+		if !current.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant scope " + scope})
+			return
+		}
+	}
+
+	apiKey, key, err := issueAPIKey(c.Request.Context(), middleware.CurrentUser(c).ID, req.Name, scopes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, toAPIKeyResponse(apiKey, key))
+}
+
+// RevokeAPIKey revokes one of the caller's API keys
+func RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	apiKey, err := store.Users.FindAPIKey(ctx, middleware.CurrentUser(c).ID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if apiKey.RevokedAt == nil {
+		if err := store.Users.RevokeAPIKey(ctx, apiKey, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+// issueAPIKey generates and stores a key, returning the model and the
+// plain key which is never persisted
+func issueAPIKey(ctx context.Context, userID uint, name string, scopes []string) (models.APIKey, string, error) {
+	key, err := utils.GenerateAPIKey()
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	apiKey := models.APIKey{
+		UserID:  userID,
+		Name:    name,
+		Prefix:  key[:utils.APIKeyDisplayLength],
+		KeyHash: utils.HashAPIKey(key),
+		Scopes:  strings.Join(scopes, " "),
+	}
+	if err := store.Users.CreateAPIKey(ctx, &apiKey); err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// toAPIKeyResponse converts an API key model into its API representation
+func toAPIKeyResponse(apiKey models.APIKey, key string) models.APIKeyResponse {
+	return models.APIKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     apiKey.ScopeList(),
+		Key:        key,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
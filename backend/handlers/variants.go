@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"shorter-backend/models"
+	"shorter-backend/store"
+	"shorter-backend/uniques"
+	"shorter-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxVariants bounds the variants of a single link
+const maxVariants = 10
+
+// maxVariantStats bounds the variants listed in statistics, including
+// removed ones
+const maxVariantStats = 100
+
+// variantCookieMaxAge is how long a visitor stays on the variant they were
+// first sent to
+const variantCookieMaxAge = 30 * 24 * time.Hour
+
+// variantParam is the query parameter that tells a destination page the
+// variant it was reached through
+const variantParam = "shorter_variant"
+
+// variantCookie names the cookie holding a visitor's variant of a link
+func variantCookie(shortCode string) string {
+	return "shorter_variant_" + shortCode
+}
+
+// chooseVariant returns the variant of the link the visitor is on. Visitors
+// keep the variant named by their cookie while it still gets traffic; new
+// visitors are assigned one by weight and given the cookie.
+func chooseVariant(c *gin.Context, url models.URL) models.Variant {
+	if name, err := c.Cookie(variantCookie(url.ShortCode)); err == nil {
+		if variant, ok := url.Variants.Find(name); ok && variant.Weight > 0 {
+			return variant
+		}
+	}
+
+	variant := pickVariant(url.Variants)
+	// Named after the link, with a path of / so that the conversion
+	// endpoint receives it too
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(variantCookie(url.ShortCode), variant.Name, int(variantCookieMaxAge.Seconds()),
+		"/", "", c.Request.TLS != nil, true)
+	return variant
+}
+
+// pickVariant picks a variant at random, in proportion to the weights
+func pickVariant(variants models.Variants) models.Variant {
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return variants[0]
+	}
+
+	n := rand.Intn(total)
+	for _, variant := range variants {
+		if n < variant.Weight {
+			return variant
+		}
+		n -= variant.Weight
+	}
+	return variants[len(variants)-1]
+}
+
+// normalizeVariants validates the variants of a link and returns them in
+// the form they are stored in: destinations normalized and every variant
+// named. The error is meant for the client.
+func normalizeVariants(variants models.Variants) (models.Variants, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) > maxVariants {
+		return nil, fmt.Errorf("A link can have at most %d variants", maxVariants)
+	}
+
+	normalized := make(models.Variants, 0, len(variants))
+	names := make(map[string]bool, len(variants))
+	total := 0
+	for i, variant := range variants {
+		variant.Name = strings.TrimSpace(variant.Name)
+		if variant.Name == "" {
+			variant.Name = fmt.Sprintf("variant-%d", i+1)
+		}
+		if len(variant.Name) > maxRuleNameLength {
+			return nil, fmt.Errorf("Variant names can be at most %d characters", maxRuleNameLength)
+		}
+		if names[variant.Name] {
+			return nil, fmt.Errorf("Variant name %q is used twice", variant.Name)
+		}
+		names[variant.Name] = true
+
+		variant.URL = utils.NormalizeURL(strings.TrimSpace(variant.URL))
+		if !utils.IsValidURL(variant.URL) {
+			return nil, fmt.Errorf("Variant %q has an invalid URL", variant.Name)
+		}
+		if variant.Weight < 0 {
+			return nil, fmt.Errorf("Variant %q has a negative weight", variant.Name)
+		}
+		total += variant.Weight
+
+		normalized = append(normalized, variant)
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("At least one variant needs a positive weight")
+	}
+	return normalized, nil
+}
+
+// RecordConversion counts a conversion for a variant of a split test. The
+// destination page reports it with the variant it received in variantParam
+// as the variant parameter, which defaults to the visitor's variant cookie
+// for pages on the same site; GET allows a tracking pixel. Each visitor converts at most once per variant, so repeated
+// reports succeed without being counted again.
+func RecordConversion(c *gin.Context) {
+	shortCode := c.Param("code")
+	if shortCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short code is required"})
+		return
+	}
+
+	url, err := lookupURL(c.Request.Context(), shortCode)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Short URL not found"})
+		return
+	}
+	if len(url.Variants) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Short URL has no variants"})
+		return
+	}
+
+	name := c.Query("variant")
+	if name == "" {
+		name = c.PostForm("variant")
+	}
+	if name == "" {
+		name, _ = c.Cookie(variantCookie(shortCode))
+	}
+	if _, ok := url.Variants.Find(name); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown variant"})
+		return
+	}
+
+	conversion := models.Conversion{
+		URLId:     url.ID,
+		Variant:   name,
+		VisitorID: uniques.Visitor(utils.GetClientIP(c.Request), c.Request.UserAgent()),
+		CreatedAt: time.Now(),
+	}
+	err = store.Conversions.Create(c.Request.Context(), &conversion)
+	if err != nil && !errors.Is(err, store.ErrDuplicate) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record conversion"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusNoContent)
+}
+
+// variantStats breaks a link's clicks down per variant, with the distinct
+// visitors and conversions of each. Conversion rates are per visitor, as
+// each visitor converts at most once. Variants still on the link come first, in
+// their order, followed by removed ones that have clicks or conversions.
+func variantStats(clicks, visitors, conversions []store.ValueCount, variants models.Variants) []models.VariantClickStat {
+	clickCounts := make(map[string]int64, len(clicks))
+	for _, v := range clicks {
+		clickCounts[v.Value] = v.Count
+	}
+	visitorCounts := make(map[string]int64, len(visitors))
+	for _, v := range visitors {
+		visitorCounts[v.Value] = v.Count
+	}
+	conversionCounts := make(map[string]int64, len(conversions))
+	for _, v := range conversions {
+		conversionCounts[v.Value] = v.Count
+	}
+
+	stat := func(name string, weight int) models.VariantClickStat {
+		s := models.VariantClickStat{
+			Variant:     name,
+			Weight:      weight,
+			Count:       clickCounts[name],
+			Visitors:    visitorCounts[name],
+			Conversions: conversionCounts[name],
+		}
+		if s.Visitors > 0 {
+			s.ConversionRate = float64(s.Conversions) / float64(s.Visitors)
+		}
+		return s
+	}
+
+	stats := make([]models.VariantClickStat, 0, len(variants))
+	listed := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		stats = append(stats, stat(variant.Name, variant.Weight))
+		listed[variant.Name] = true
+	}
+	for _, values := range [][]store.ValueCount{clicks, visitors, conversions} {
+		for _, v := range values {
+			if !listed[v.Value] {
+				stats = append(stats, stat(v.Value, 0))
+				listed[v.Value] = true
+			}
+		}
+	}
+	return stats
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"shorter-backend/models"
+	"shorter-backend/store"
+	"shorter-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// splitLink creates a link splitting traffic between two variants
+func splitLink(t *testing.T, link models.URL) {
+	t.Helper()
+	link.OriginalURL = "https://example.com"
+	link.ShortCode = "split"
+	link.Variants = models.Variants{
+		{Name: "a", URL: "https://example.com/a", Weight: 1},
+		{Name: "b", URL: "https://example.com/b", Weight: 1},
+	}
+	if err := store.Links.Create(context.Background(), &link); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVariantAssignedOnlyOnRedirect(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	hash, err := utils.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		link       models.URL
+		method     string
+		password   string
+		status     int
+		wantCookie bool
+	}{
+		{name: "redirect", method: http.MethodGet, status: http.StatusFound, wantCookie: true},
+		{name: "expired", link: models.URL{ExpiresAt: &past}, method: http.MethodGet, status: http.StatusGone},
+		{name: "used up", link: models.URL{MaxClicks: 1, UsedClicks: 1}, method: http.MethodGet, status: http.StatusGone},
+		{name: "password form", link: models.URL{PasswordHash: hash}, method: http.MethodGet, status: http.StatusOK},
+		{name: "wrong password", link: models.URL{PasswordHash: hash}, method: http.MethodPost, password: "guess", status: http.StatusUnauthorized},
+		{name: "unlocked", link: models.URL{PasswordHash: hash}, method: http.MethodPost, password: "secret", status: http.StatusSeeOther, wantCookie: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			usePipeline(t)
+			splitLink(t, tt.link)
+
+			router := gin.New()
+			router.GET("/:code", RedirectURL)
+			router.POST("/:code", UnlockURL)
+
+			var body *strings.Reader
+			if tt.method == http.MethodPost {
+				body = strings.NewReader(url.Values{"password": {tt.password}}.Encode())
+			} else {
+				body = strings.NewReader("")
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/split", body)
+			req.Header.Set("User-Agent", browserUA)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("%s /split = %d, want %d", tt.method, w.Code, tt.status)
+			}
+			cookie := w.Header().Get("Set-Cookie")
+			if gotCookie := strings.HasPrefix(cookie, variantCookie("split")+"="); gotCookie != tt.wantCookie {
+				t.Errorf("%s /split set cookie %q, want variant cookie %v", tt.method, cookie, tt.wantCookie)
+			}
+		})
+	}
+}
+
+func TestVariantReachesConversions(t *testing.T) {
+	tests := []struct {
+		name string
+		// report builds the conversion request from the redirect's
+		// destination and variant cookie
+		report func(destination *url.URL, cookie *http.Cookie) string
+		cookie bool
+	}{
+		{
+			name: "page on another site echoes the parameter",
+			report: func(destination *url.URL, cookie *http.Cookie) string {
+				return "/api/convert/split?variant=" + url.QueryEscape(destination.Query().Get(variantParam))
+			},
+		},
+		{
+			name: "page on the same site relies on the cookie",
+			report: func(destination *url.URL, cookie *http.Cookie) string {
+				return "/api/convert/split"
+			},
+			cookie: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryStore(t)
+			usePipeline(t)
+			splitLink(t, models.URL{})
+
+			router := gin.New()
+			router.GET("/:code", RedirectURL)
+			router.GET("/api/convert/:code", RecordConversion)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/split", nil)
+			req.Header.Set("User-Agent", browserUA)
+			router.ServeHTTP(w, req)
+
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Path != "/" {
+				t.Fatalf("redirect set cookies %v, want one variant cookie on /", cookies)
+			}
+			destination, err := url.Parse(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			variant := cookies[0].Value
+			if got := destination.Query().Get(variantParam); got != variant {
+				t.Fatalf("redirect to %s, want %s=%s", destination, variantParam, variant)
+			}
+			if want := "/" + variant; destination.Path != want {
+				t.Fatalf("redirect to %s, want the path of variant %s", destination, variant)
+			}
+
+			convert := httptest.NewRequest(http.MethodGet, tt.report(destination, cookies[0]), nil)
+			convert.Header.Set("User-Agent", browserUA)
+			if tt.cookie {
+				convert.AddCookie(cookies[0])
+			}
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, convert)
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("conversion = %d %s, want %d", w.Code, w.Body, http.StatusNoContent)
+			}
+
+			counts, err := store.Conversions.CountByVariant(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(counts) != 1 || counts[0].Value != variant || counts[0].Count != 1 {
+				t.Errorf("conversions = %+v, want one for variant %q", counts, variant)
+			}
+		})
+	}
+}
+
+func TestRecordConversionOncePerVisitor(t *testing.T) {
+	useMemoryStore(t)
+	splitLink(t, models.URL{})
+
+	router := gin.New()
+	router.GET("/api/convert/:code", RecordConversion)
+
+	reports := []struct {
+		variant string
+		ua      string
+		status  int
+	}{
+		{"a", browserUA, http.StatusNoContent},
+		{"a", browserUA, http.StatusNoContent},
+		{"b", browserUA, http.StatusNoContent},
+		{"a", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)", http.StatusNoContent},
+		{"c", browserUA, http.StatusBadRequest},
+		{"", browserUA, http.StatusBadRequest},
+	}
+	for _, r := range reports {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/convert/split?variant="+r.variant, nil)
+		req.Header.Set("User-Agent", r.ua)
+		router.ServeHTTP(w, req)
+		if w.Code != r.status {
+			t.Errorf("conversion for %q = %d, want %d", r.variant, w.Code, r.status)
+		}
+	}
+
+	counts, err := store.Conversions.CountByVariant(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]int64)
+	for _, c := range counts {
+		got[c.Value] = c.Count
+	}
+	if got["a"] != 2 || got["b"] != 1 {
+		t.Errorf("conversions = %v, want a: 2 and b: 1", got)
+	}
+}
+
+func TestVariantStatsRatePerVisitor(t *testing.T) {
+	variants := models.Variants{{Name: "a", Weight: 1}, {Name: "b", Weight: 0}}
+	clicks := []store.ValueCount{{Value: "a", Count: 10}, {Value: "b", Count: 4}, {Value: "old", Count: 2}}
+	visitors := []store.ValueCount{{Value: "a", Count: 4}, {Value: "b", Count: 2}, {Value: "old", Count: 1}}
+	conversions := []store.ValueCount{{Value: "a", Count: 2}}
+
+	want := []models.VariantClickStat{
+		{Variant: "a", Weight: 1, Count: 10, Visitors: 4, Conversions: 2, ConversionRate: 0.5},
+		{Variant: "b", Weight: 0, Count: 4, Visitors: 2},
+		{Variant: "old", Count: 2, Visitors: 1},
+	}
+	got := variantStats(clicks, visitors, conversions, variants)
+	if len(got) != len(want) {
+		t.Fatalf("variantStats() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("variantStats()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package lifecycle tracks whether the server is ready for traffic and runs
+// the registered shutdown hooks in order when it stops.
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	ready atomic.Bool
+
+	mu    sync.Mutex
+	hooks []hook
+)
+
+// SetReady marks the server as ready (or not) to receive traffic
+func SetReady(r bool) {
+	ready.Store(r)
+}
+
+// IsReady reports whether the server is accepting traffic
+func IsReady() bool {
+	return ready.Load()
+}
+
+// OnShutdown registers a hook to run during Shutdown. Hooks run in reverse
+// order of registration, so something registered after its dependencies is
+// stopped before them.
+func OnShutdown(name string, fn func(ctx context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = append(hooks, hook{name: name, fn: fn})
+}
+
+// Shutdown runs every registered hook, newest first, logging failures and
+// carrying on with the rest
+func Shutdown(ctx context.Context) {
+	mu.Lock()
+	pending := hooks
+	hooks = nil
+	mu.Unlock()
+
+	for i := len(pending) - 1; i >= 0; i-- {
+		h := pending[i]
+		log.Printf("Stopping %s", h.name)
+		if err := h.fn(ctx); err != nil {
+			log.Printf("Failed to stop %s: %v", h.name, err)
+		}
+	}
+}
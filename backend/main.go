@@ -1,12 +1,29 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"shorter-backend/analytics"
+	"shorter-backend/botdetect"
 	"shorter-backend/config"
+	"shorter-backend/geoip"
 	"shorter-backend/handlers"
+	"shorter-backend/lifecycle"
+	"shorter-backend/metadata"
 	"shorter-backend/middleware"
+	"shorter-backend/models"
+	"shorter-backend/rollups"
+	"shorter-backend/store"
+	"shorter-backend/stream"
+	"shorter-backend/uniques"
+	"shorter-backend/useragent"
+	"shorter-backend/utils"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -16,6 +33,70 @@ func main() {
 	// Initialize database connections
 	config.ConnectDatabase()
 	config.ConnectRedis()
+	lifecycle.OnShutdown("database connections", func(ctx context.Context) error {
+		return config.Close()
+	})
+
+	// Use the SQL database when one is configured, otherwise keep
+	// everything in memory
+	if config.DB != nil {
+		store.Use(store.NewGormBackend(config.DB))
+	} else {
+		store.Use(store.NewMemoryBackend())
+	}
+
+	// Proxies allowed to report client addresses (loopback by default)
+	trustedProxies := config.GetEnv("TRUSTED_PROXIES", "127.0.0.0/8,::1")
+	if err := utils.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if err := utils.SetTrustedProxyHeader(config.GetEnv("TRUSTED_PROXY_HEADER", "X-Forwarded-For")); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXY_HEADER: %v", err)
+	}
+
+	// Offline IP geolocation for clicks
+	geoip.Init()
+	lifecycle.OnShutdown("geoip", func(ctx context.Context) error {
+		return geoip.Default.Close()
+	})
+
+	// Known crawler networks for bot detection
+	botdetect.Init()
+
+	// Unique visitor sketches (Redis when available)
+	uniques.Init()
+
+	// Live click streams (fanned out through Redis when available)
+	stream.Init()
+
+	// Start the click ingestion pipeline
+	analytics.Init()
+	analytics.Default.Use(geoip.Default.Enrich)
+	analytics.Default.Use(useragent.Enrich)
+	analytics.Default.Use(botdetect.Default.Enrich)
+	analytics.Default.Use(uniques.Enrich)
+	analytics.Default.OnWrite(uniques.Record)
+	analytics.Default.OnWrite(rollups.Record)
+	analytics.Default.OnWrite(stream.Default.Record)
+	analytics.Default.Start()
+	lifecycle.OnShutdown("click pipeline", analytics.Default.Stop)
+
+	// Background fetching of link titles and previews
+	metadata.Init()
+	lifecycle.OnShutdown("metadata fetcher", metadata.Default.Stop)
+
+	// Rate limiters (shared through Redis when configured)
+	middleware.InitLimiters()
+	lifecycle.OnShutdown("rate limiters", func(ctx context.Context) error {
+		middleware.StopLimiters()
+		return nil
+	})
+	config.BootstrapAdmin()
+
+	// App association files for deep links on the short link domain
+	if err := handlers.LoadAppLinks(); err != nil {
+		log.Fatalf("Failed to load app link files: %v", err)
+	}
 
 	// Initialize Gin router
 	r := gin.Default()
@@ -33,8 +114,8 @@ func main() {
 		corsConfig.AllowAllOrigins = true
 	}
 	
-	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"}
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "X-Requested-With"}
 	corsConfig.ExposeHeaders = []string{"Content-Length"}
 	corsConfig.AllowCredentials = true
 
@@ -51,36 +132,85 @@ func main() {
 	// Advanced health check
 	r.GET("/health/detailed", handlers.GetDetailedHealth)
 
-	// Admin routes (consider adding authentication in production)
+	// Admin routes (admin role required)
 	admin := r.Group("/admin")
+	admin.Use(middleware.Authenticate(), middleware.Quota(middleware.ActionRequests))
+	admin.Use(middleware.RequireRole(models.RoleAdmin))
 	{
 		admin.GET("/stats", handlers.GetSystemStats)
 		admin.GET("/activity", handlers.GetRecentActivity)
+		admin.GET("/stream", handlers.StreamAllClicks)
+		admin.GET("/users", handlers.ListUsers)
+		admin.PUT("/users/:id/role", handlers.UpdateUserRole)
+		admin.PUT("/users/:id/plan", handlers.UpdateUserPlan)
 	}
 
-	// API routes
+	// API routes, limited by the caller's quota plan
 	api := r.Group("/api")
-	api.Use(middleware.RateLimitMiddleware(middleware.GeneralLimiter))
+	api.Use(middleware.Authenticate(), middleware.Quota(middleware.ActionRequests))
 	{
-		// URL shortening (with stricter rate limit)
-		api.POST("/shorten", middleware.RateLimitMiddleware(middleware.CreateURLLimiter), handlers.ShortenURL)
+		// Account registration (returns the first API key)
+		api.POST("/users", middleware.RateLimitMiddleware(middleware.SignupLimiter), handlers.RegisterUser)
+		api.GET("/me", middleware.RequireScope(models.ScopeLinksRead), handlers.GetCurrentUser)
+
+		// Plan limits and quota usage of the caller
+		api.GET("/usage", handlers.GetUsage)
+
+		// API key management
+		keys := api.Group("/keys", middleware.RequireScope(models.ScopeKeysManage))
+		{
+			keys.GET("", handlers.ListAPIKeys)
+			keys.POST("", handlers.CreateAPIKey)
+			keys.DELETE("/:id", handlers.RevokeAPIKey)
+		}
+
+		// URL shortening (counted against the link quotas)
+		api.POST("/shorten", middleware.OptionalScope(models.ScopeLinksWrite), middleware.Quota(middleware.ActionLinks), handlers.ShortenURL)
+		
+		// Get the caller's URLs with pagination (anonymous callers see
+		// anonymous links)
+		api.GET("/urls", middleware.OptionalScope(models.ScopeLinksRead), handlers.GetAllURLs)
+
+		// Update or delete an existing short URL
+		api.PUT("/urls/:code", middleware.RequireScope(models.ScopeLinksWrite), middleware.RequireRole(models.RoleEditor), handlers.UpdateURL)
+		api.PATCH("/urls/:code", middleware.RequireScope(models.ScopeLinksWrite), middleware.RequireRole(models.RoleEditor), handlers.UpdateURL)
+		api.DELETE("/urls/:code", middleware.RequireScope(models.ScopeLinksWrite), middleware.RequireRole(models.RoleEditor), handlers.DeleteURL)
 		
-		// Get all URLs with pagination
-		api.GET("/urls", handlers.GetAllURLs)
+		// Get statistics for a specific short URL (of an anonymous link for
+		// anonymous callers)
+		api.GET("/stats/:code", middleware.OptionalScope(models.ScopeStatsRead), handlers.GetURLStats)
+		api.GET("/stats/:code/timeseries", middleware.OptionalScope(models.ScopeStatsRead), handlers.GetURLTimeseries)
+		api.GET("/stats/:code/stream", middleware.OptionalScope(models.ScopeStatsRead), handlers.StreamURLClicks)
 		
-		// Get statistics for a specific short URL
-		api.GET("/stats/:code", handlers.GetURLStats)
+		// Conversions of split test variants, reported by destination pages
+		// (GET for tracking pixels)
+		api.GET("/convert/:code", middleware.RateLimitMiddleware(middleware.ConversionLimiter), handlers.RecordConversion)
+		api.POST("/convert/:code", middleware.RateLimitMiddleware(middleware.ConversionLimiter), handlers.RecordConversion)
 		
-		// QR Code generation (with specific rate limit)
-		qr := api.Group("/qr", middleware.RateLimitMiddleware(middleware.QRCodeLimiter))
+		// QR Code generation (counted against the QR code quotas)
+		qr := api.Group("/qr", middleware.Quota(middleware.ActionQR))
 		{
 			qr.GET("/:code/image", handlers.GenerateQRCode)
 			qr.GET("/:code", handlers.GetQRCodeHTML)
 		}
 	}
 
+	// Deep-link association files (iOS also looks for the legacy path)
+	r.GET("/.well-known/apple-app-site-association", handlers.GetAppleAppSiteAssociation)
+	r.GET("/apple-app-site-association", handlers.GetAppleAppSiteAssociation)
+	r.GET("/.well-known/assetlinks.json", handlers.GetAndroidAssetLinks)
+
 	// Redirect routes (without /api prefix for clean short URLs)
 	r.GET("/:code", handlers.RedirectURL)
+	r.HEAD("/:code", handlers.RedirectURL)
+
+	// Links that forward paths append whatever follows the code
+	r.GET("/:code/*rest", handlers.RedirectURL)
+	r.HEAD("/:code/*rest", handlers.RedirectURL)
+
+	// Password submission for protected links (throttled against brute force)
+	r.POST("/:code", middleware.RateLimitMiddleware(middleware.PasswordLimiter), handlers.UnlockURL)
+	r.POST("/:code/*rest", middleware.RateLimitMiddleware(middleware.PasswordLimiter), handlers.UnlockURL)
 
 	// Static file serving for frontend (if built)
 	r.Static("/static", "./static")
@@ -97,6 +227,44 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+	// Open event streams never finish on their own; end them as soon as
+	// shutdown starts so in-flight requests can drain
+	srv.RegisterOnShutdown(func() {
+		stream.Default.Close()
+	})
+
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	lifecycle.SetReady(true)
+
+	// Wait for SIGINT/SIGTERM
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	// Report not-ready first and keep serving for the drain period so load
+	// balancers stop routing new traffic here before we stop listening
+	lifecycle.SetReady(false)
+	drain := config.GetEnvDuration("SHUTDOWN_DRAIN_PERIOD", 5*time.Second)
+	log.Printf("Shutting down, draining for %s", drain)
+	time.Sleep(drain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second))
+	defer cancel()
+
+	// Finish in-flight requests, then stop background workers
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server did not shut down cleanly: %v", err)
+	}
+	lifecycle.Shutdown(ctx)
+
+	log.Println("Server stopped")
 } 
\ No newline at end of file
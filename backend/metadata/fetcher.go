@@ -0,0 +1,180 @@
+// Package metadata reads the title, description, image and site name of
+// link destinations from their HTML and Open Graph or Twitter card tags.
+// Pages are fetched in the background, within size and time limits and
+// only where the site's robots.txt allows it.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"shorter-backend/models"
+
+	"golang.org/x/net/html/charset"
+)
+
+var (
+	// ErrDisallowed is returned when robots.txt forbids fetching a page
+	ErrDisallowed = errors.New("disallowed by robots.txt")
+	// ErrNotHTML is returned for destinations that aren't HTML pages
+	ErrNotHTML = errors.New("not an HTML page")
+	// errPrivateAddress refuses connections into private networks
+	errPrivateAddress = errors.New("refusing to connect to a private address")
+)
+
+// Fetcher downloads pages and reads their metadata
+type Fetcher struct {
+	client    *http.Client
+	userAgent string
+	agent     string
+	maxBytes  int64
+	timeout   time.Duration
+	robots    robotsCache
+}
+
+// NewFetcher creates a fetcher sending requests through client as
+// userAgent. At most maxBytes of a page are read and a fetch, robots.txt
+// included, gives up after timeout.
+func NewFetcher(client *http.Client, userAgent string, maxBytes int64, timeout time.Duration) *Fetcher {
+	// robots.txt groups are matched against the product token
+	agent, _, _ := strings.Cut(userAgent, "/")
+	return &Fetcher{
+		client:    client,
+		userAgent: userAgent,
+		agent:     strings.TrimSpace(agent),
+		maxBytes:  maxBytes,
+		timeout:   timeout,
+	}
+}
+
+// Fetch downloads the page at rawURL and reads its metadata
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (models.PageMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return models.PageMetadata{}, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return models.PageMetadata{}, fmt.Errorf("unsupported scheme %q", target.Scheme)
+	}
+
+	rules, err := f.robotsRules(ctx, target)
+	if err != nil {
+		return models.PageMetadata{}, err
+	}
+	if !rules.allowed(target.RequestURI()) {
+		return models.PageMetadata{}, ErrDisallowed
+	}
+
+	resp, err := f.get(ctx, target.String(), "text/html,application/xhtml+xml")
+	if err != nil {
+		return models.PageMetadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return models.PageMetadata{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return models.PageMetadata{}, ErrNotHTML
+	}
+
+	var body io.Reader = io.LimitReader(resp.Body, f.maxBytes)
+	if decoded, err := charset.NewReader(body, contentType); err == nil {
+		body = decoded
+	}
+	// Redirects may have moved the page; relative images resolve against
+	// where it ended up
+	return Parse(body, resp.Request.URL), nil
+}
+
+// robotsRules returns the site's robots.txt rules for this fetcher. A
+// missing robots.txt allows everything, while a server error disallows
+// everything until it is read again. An error means the site couldn't be
+// reached at all.
+func (f *Fetcher) robotsRules(ctx context.Context, target *url.URL) (robotsRules, error) {
+	site := target.Scheme + "://" + target.Host
+	now := time.Now()
+	if rules, ok := f.robots.get(site, now); ok {
+		return rules, nil
+	}
+
+	resp, err := f.get(ctx, site+"/robots.txt", "text/plain")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	rules := disallowAll
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		rules = parseRobots(io.LimitReader(resp.Body, f.maxBytes), f.agent)
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		rules = nil
+	}
+
+	f.robots.put(site, rules, now)
+	return rules, nil
+}
+
+// get sends a GET request as the fetcher
+func (f *Fetcher) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept", accept)
+	return f.client.Do(req)
+}
+
+// maxRedirects bounds the redirects followed to reach a page
+const maxRedirects = 5
+
+// NewPublicClient creates an HTTP client for fetching user-supplied URLs.
+// It refuses to connect to loopback, private and link-local addresses, so
+// links can't be used to probe the internal network, checking the address
+// actually dialled so DNS tricks and redirects don't get around it.
+func NewPublicClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,169 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSite serves robots.txt with the given status and body, and pages
+// from the handlers in pages
+func newTestSite(t *testing.T, robotsStatus int, robots string, pages map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(robotsStatus)
+		w.Write([]byte(robots))
+	})
+	for path, handler := range pages {
+		mux.HandleFunc(path, handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func htmlPage(contentType, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}
+}
+
+func TestFetcherFetch(t *testing.T) {
+	pages := map[string]http.HandlerFunc{
+		"/page":   htmlPage("text/html; charset=utf-8", `<head><title>Page</title><meta property="og:image" content="/cover.png"></head>`),
+		"/latin1": htmlPage("text/html; charset=iso-8859-1", "<head><title>Caf\xe9</title></head>"),
+		"/image":  htmlPage("image/png", "\x89PNG"),
+		"/missing": func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		},
+		"/moved": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/docs/final", http.StatusFound)
+		},
+		"/docs/final":   htmlPage("text/html", `<head><meta property="og:image" content="img.png"></head>`),
+		"/private/page": htmlPage("text/html", `<head><title>Private</title></head>`),
+	}
+	server := newTestSite(t, http.StatusOK, "User-agent: *\nDisallow: /private/", pages)
+	fetcher := NewFetcher(&http.Client{}, "ShorterBot/1.0", 64*1024, 5*time.Second)
+
+	tests := []struct {
+		path      string
+		wantTitle string
+		wantImage string
+		wantErr   error
+		anyErr    bool
+	}{
+		{path: "/page", wantTitle: "Page", wantImage: server.URL + "/cover.png"},
+		{path: "/latin1", wantTitle: "Café"},
+		{path: "/moved", wantImage: server.URL + "/docs/img.png"},
+		{path: "/image", wantErr: ErrNotHTML},
+		{path: "/private/page", wantErr: ErrDisallowed},
+		{path: "/missing", anyErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			meta, err := fetcher.Fetch(context.Background(), server.URL+tt.path)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Fetch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.anyErr:
+				if err == nil {
+					t.Fatal("Fetch() succeeded, want an error")
+				}
+				return
+			case err != nil:
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if meta.Title != tt.wantTitle || meta.Image != tt.wantImage {
+				t.Errorf("Fetch() = %+v, want title %q and image %q", meta, tt.wantTitle, tt.wantImage)
+			}
+		})
+	}
+}
+
+func TestFetcherRobotsStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr error
+	}{
+		{"missing robots.txt allows everything", http.StatusNotFound, nil},
+		{"server error disallows everything", http.StatusServiceUnavailable, ErrDisallowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestSite(t, tt.status, "", map[string]http.HandlerFunc{
+				"/page": htmlPage("text/html", `<head><title>Page</title></head>`),
+			})
+			fetcher := NewFetcher(&http.Client{}, "ShorterBot/1.0", 64*1024, 5*time.Second)
+
+			if _, err := fetcher.Fetch(context.Background(), server.URL+"/page"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Fetch() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetcherLimits(t *testing.T) {
+	padding := strings.Repeat(" ", 4096)
+	server := newTestSite(t, http.StatusNotFound, "", map[string]http.HandlerFunc{
+		"/large": htmlPage("text/html", "<head>"+padding+"<title>Too far</title></head>"),
+		"/slow": func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+			}
+		},
+	})
+
+	t.Run("size", func(t *testing.T) {
+		fetcher := NewFetcher(&http.Client{}, "ShorterBot/1.0", 1024, 5*time.Second)
+		meta, err := fetcher.Fetch(context.Background(), server.URL+"/large")
+		if err != nil {
+			t.Fatalf("Fetch() error = %v", err)
+		}
+		if meta.Title != "" {
+			t.Errorf("Fetch() read %q past the size limit", meta.Title)
+		}
+	})
+
+	t.Run("time", func(t *testing.T) {
+		fetcher := NewFetcher(&http.Client{}, "ShorterBot/1.0", 1024, 100*time.Millisecond)
+		start := time.Now()
+		if _, err := fetcher.Fetch(context.Background(), server.URL+"/slow"); err == nil {
+			t.Fatal("Fetch() succeeded, want a timeout")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Fetch() took %v, want it to give up after the timeout", elapsed)
+		}
+	})
+}
+
+func TestFetcherRejectsOtherSchemes(t *testing.T) {
+	fetcher := NewFetcher(&http.Client{}, "ShorterBot/1.0", 1024, time.Second)
+	if _, err := fetcher.Fetch(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("Fetch() accepted a file URL")
+	}
+}
+
+func TestPublicClientRefusesPrivateAddresses(t *testing.T) {
+	server := newTestSite(t, http.StatusNotFound, "", map[string]http.HandlerFunc{
+		"/page": htmlPage("text/html", `<head><title>Internal</title></head>`),
+	})
+	fetcher := NewFetcher(NewPublicClient(), "ShorterBot/1.0", 1024, time.Second)
+
+	_, err := fetcher.Fetch(context.Background(), server.URL+"/page")
+	if err == nil || !errors.Is(err, errPrivateAddress) {
+		t.Errorf("Fetch() error = %v, want %v", err, errPrivateAddress)
+	}
+}
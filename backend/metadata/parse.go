@@ -0,0 +1,129 @@
+package metadata
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"shorter-backend/models"
+
+	"golang.org/x/net/html"
+)
+
+// Longest values kept; pages sometimes stuff whole articles into tags
+const (
+	maxTextLength = 500
+	maxURLLength  = 2048
+)
+
+// Parse reads the metadata in the head of an HTML document. Open Graph tags
+// win over Twitter card tags, which win over <title> and the description
+// meta tag. A relative image URL is resolved against base.
+func Parse(r io.Reader, base *url.URL) models.PageMetadata {
+	tags := make(map[string]string)
+	var title string
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+
+		name, hasAttr := z.TagName()
+		if tt == html.EndTagToken && string(name) == "head" {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		switch string(name) {
+		case "body":
+			// Metadata only counts in the head
+			return pick(tags, title, base)
+		case "title":
+			if title == "" && z.Next() == html.TextToken {
+				title = string(z.Text())
+			}
+		case "meta":
+			if !hasAttr {
+				continue
+			}
+			var key, content string
+			for {
+				attr, value, more := z.TagAttr()
+				switch strings.ToLower(string(attr)) {
+				case "property", "name":
+					if key == "" {
+						key = strings.ToLower(strings.TrimSpace(string(value)))
+					}
+				case "content":
+					content = string(value)
+				}
+				if !more {
+					break
+				}
+			}
+			if _, seen := tags[key]; key != "" && !seen {
+				tags[key] = content
+			}
+		}
+	}
+
+	return pick(tags, title, base)
+}
+
+// pick chooses each field from the first tag that sets it
+func pick(tags map[string]string, title string, base *url.URL) models.PageMetadata {
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value := clean(tags[key]); value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	meta := models.PageMetadata{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    first("og:site_name", "application-name"),
+		Image:       resolveImage(first("og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src"), base),
+	}
+	if meta.Title == "" {
+		meta.Title = clean(title)
+	}
+	return meta
+}
+
+// clean collapses whitespace and shortens overlong values
+func clean(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if runes := []rune(value); len(runes) > maxTextLength {
+		value = string(runes[:maxTextLength])
+	}
+	return value
+}
+
+// resolveImage makes an image reference absolute, dropping anything that
+// isn't a web URL
+func resolveImage(ref string, base *url.URL) string {
+	if ref == "" {
+		return ""
+	}
+	image, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		image = base.ResolveReference(image)
+	}
+	if image.Scheme != "http" && image.Scheme != "https" {
+		return ""
+	}
+	if resolved := image.String(); len(resolved) <= maxURLLength {
+		return resolved
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package metadata
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"shorter-backend/models"
+)
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+
+	tests := []struct {
+		name string
+		html string
+		want models.PageMetadata
+	}{
+		{
+			name: "title and description",
+			html: `<html><head><title> Hello
+				World </title><meta name="description" content="A page"></head></html>`,
+			want: models.PageMetadata{Title: "Hello World", Description: "A page"},
+		},
+		{
+			name: "Open Graph wins over Twitter and title",
+			html: `<head>
+				<title>Plain</title>
+				<meta name="twitter:title" content="Twitter">
+				<meta property="og:title" content="Open Graph">
+				<meta name="description" content="Plain description">
+				<meta name="twitter:description" content="Twitter description">
+				<meta property="og:site_name" content="Example">
+			</head>`,
+			want: models.PageMetadata{Title: "Open Graph", Description: "Twitter description", SiteName: "Example"},
+		},
+		{
+			name: "Twitter card when there is no Open Graph",
+			html: `<head><title>Plain</title><meta name="twitter:title" content="Twitter"><meta name="twitter:image" content="https://cdn.example.com/card.png"></head>`,
+			want: models.PageMetadata{Title: "Twitter", Image: "https://cdn.example.com/card.png"},
+		},
+		{
+			name: "relative image resolved against the page",
+			html: `<head><meta property="og:image" content="../img/cover.jpg"></head>`,
+			want: models.PageMetadata{Image: "https://example.com/img/cover.jpg"},
+		},
+		{
+			name: "non-web image dropped",
+			html: `<head><meta property="og:image" content="javascript:alert(1)"></head>`,
+			want: models.PageMetadata{},
+		},
+		{
+			name: "first tag of a kind wins and keys ignore case",
+			html: `<head><META PROPERTY="OG:TITLE" CONTENT="First"><meta property="og:title" content="Second"></head>`,
+			want: models.PageMetadata{Title: "First"},
+		},
+		{
+			name: "empty Open Graph value falls through",
+			html: `<head><title>Fallback</title><meta property="og:title" content="  "></head>`,
+			want: models.PageMetadata{Title: "Fallback"},
+		},
+		{
+			name: "tags in the body are ignored",
+			html: `<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			want: models.PageMetadata{Title: "Head"},
+		},
+		{
+			name: "missing head",
+			html: `<p>no metadata here</p>`,
+			want: models.PageMetadata{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(strings.NewReader(tt.html), base); got != tt.want {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTruncatesLongValues(t *testing.T) {
+	long := strings.Repeat("é", maxTextLength+50)
+	got := Parse(strings.NewReader(`<head><title>`+long+`</title></head>`), nil)
+	if n := len([]rune(got.Title)); n != maxTextLength {
+		t.Errorf("Parse() kept a title of %d characters, want %d", n, maxTextLength)
+	}
+}
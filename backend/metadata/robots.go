@@ -0,0 +1,149 @@
+package metadata
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsTTL is how long a site's robots.txt is trusted before it is read
+// again
+const robotsTTL = time.Hour
+
+// robotsRule allows or disallows the paths matching pattern
+type robotsRule struct {
+	allow   bool
+	pattern *regexp.Regexp
+	length  int
+}
+
+// robotsRules are the rules of robots.txt that apply to one crawler; no
+// rules allow everything
+type robotsRules []robotsRule
+
+// disallowAll is assumed while a site's robots.txt can't be read
+var disallowAll = robotsRules{{allow: false, pattern: regexp.MustCompile(`^/`), length: 1}}
+
+// parseRobots reads the rules robots.txt sets for agent, or for every
+// crawler ("*") when it has no group of its own (RFC 9309)
+func parseRobots(r io.Reader, agent string) robotsRules {
+	agent = strings.ToLower(agent)
+
+	var own, wildcard robotsRules
+	var matchesOwn, matchesWildcard, inRules bool
+	// hasOwn is set by a group for agent, even one without rules
+	var hasOwn bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// A user-agent line after rules starts a new group
+			if inRules {
+				matchesOwn, matchesWildcard, inRules = false, false, false
+			}
+			name := strings.ToLower(value)
+			matchesOwn = matchesOwn || name == agent
+			hasOwn = hasOwn || name == agent
+			matchesWildcard = matchesWildcard || name == "*"
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				// An empty disallow allows everything
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: robotsPattern(value), length: len(value)}
+			if matchesOwn {
+				own = append(own, rule)
+			}
+			if matchesWildcard {
+				wildcard = append(wildcard, rule)
+			}
+		}
+	}
+
+	if hasOwn {
+		return own
+	}
+	return wildcard
+}
+
+// robotsPattern compiles a path pattern where * matches anything and a
+// trailing $ anchors the end
+func robotsPattern(path string) *regexp.Regexp {
+	anchored := strings.HasSuffix(path, "$")
+	path = strings.TrimSuffix(path, "$")
+
+	pattern := "^" + strings.ReplaceAll(regexp.QuoteMeta(path), `\*`, ".*")
+	if anchored {
+		pattern += "$"
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// allowed reports whether path (with its query) may be fetched. The most
+// specific matching rule wins, and allow wins a tie.
+func (rules robotsRules) allowed(path string) bool {
+	allowed, length := true, -1
+	for _, rule := range rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+		if rule.length > length || (rule.length == length && rule.allow) {
+			allowed, length = rule.allow, rule.length
+		}
+	}
+	return allowed
+}
+
+// robotsCache keeps the rules of recently visited sites
+type robotsCache struct {
+	mu      sync.Mutex
+	entries map[string]robotsEntry
+}
+
+type robotsEntry struct {
+	rules   robotsRules
+	expires time.Time
+}
+
+func (c *robotsCache) get(site string, now time.Time) (robotsRules, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[site]
+	if !ok || !now.Before(entry.expires) {
+		return nil, false
+	}
+	return entry.rules, true
+}
+
+func (c *robotsCache) put(site string, rules robotsRules, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]robotsEntry)
+	}
+	// Forget expired sites so the cache doesn't grow without bound
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[site] = robotsEntry{rules: rules, expires: now.Add(robotsTTL)}
+}
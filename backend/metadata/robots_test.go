@@ -0,0 +1,92 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	const robots = `
+# Comments and unknown lines are ignored
+Sitemap: https://example.com/sitemap.xml
+
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.pdf$
+
+User-agent: OtherBot
+User-agent: ShorterBot
+Disallow: /
+Allow: /blog/
+
+User-agent: EmptyBot
+Disallow:
+`
+
+	tests := []struct {
+		agent string
+		path  string
+		want  bool
+	}{
+		// ShorterBot has a group of its own, shared with OtherBot
+		{"ShorterBot", "/", false},
+		{"shorterbot", "/about", false},
+		{"ShorterBot", "/blog/post", true},
+		{"OtherBot", "/blog/", true},
+		// Crawlers without a group follow the wildcard one
+		{"SomeBot", "/", true},
+		{"SomeBot", "/private/page", false},
+		{"SomeBot", "/private/public/page", true},
+		{"SomeBot", "/files/report.pdf", false},
+		{"SomeBot", "/files/report.pdf?download=1", true},
+		// An empty disallow allows everything
+		{"EmptyBot", "/private/page", true},
+	}
+
+	for _, tt := range tests {
+		rules := parseRobots(strings.NewReader(robots), tt.agent)
+		if got := rules.allowed(tt.path); got != tt.want {
+			t.Errorf("%s allowed %s = %v, want %v", tt.agent, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRobotsAllowedPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		robots string
+		path   string
+		want   bool
+	}{
+		{"longer disallow wins", "User-agent: *\nAllow: /a\nDisallow: /a/b", "/a/b/c", false},
+		{"longer allow wins", "User-agent: *\nDisallow: /a\nAllow: /a/b", "/a/b/c", true},
+		{"allow wins a tie", "User-agent: *\nDisallow: /a\nAllow: /a", "/a", true},
+		{"wildcard inside a path", "User-agent: *\nDisallow: /*/edit", "/posts/1/edit", false},
+		{"anchored pattern", "User-agent: *\nDisallow: /exact$", "/exact/more", true},
+		{"no rules", "", "/anything", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(tt.robots), "ShorterBot")
+			if got := rules.allowed(tt.path); got != tt.want {
+				t.Errorf("allowed(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobotsCacheExpires(t *testing.T) {
+	var cache robotsCache
+	now := time.Now()
+
+	cache.put("https://example.com", disallowAll, now)
+	if _, ok := cache.get("https://example.com", now.Add(robotsTTL-time.Second)); !ok {
+		t.Error("rules missing before they expire")
+	}
+	if _, ok := cache.get("https://example.com", now.Add(robotsTTL)); ok {
+		t.Error("rules still cached once expired")
+	}
+}
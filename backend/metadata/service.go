@@ -0,0 +1,228 @@
+package metadata
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"shorter-backend/config"
+	"shorter-backend/store"
+)
+
+// refreshScanInterval is how often stale links are looked for
+const refreshScanInterval = time.Minute
+
+// Config tunes the background fetching
+type Config struct {
+	Workers   int
+	QueueSize int
+	// RefreshInterval is the age at which metadata is fetched again
+	RefreshInterval time.Duration
+	// RefreshBatch bounds the stale links queued per scan
+	RefreshBatch int
+}
+
+// job is a link whose destination should be fetched
+type job struct {
+	id  uint
+	url string
+}
+
+// Service fetches link metadata in the background: new links are queued as
+// they are created and every link is fetched again once its metadata is
+// older than the refresh interval. Failed fetches keep earlier metadata and
+// are retried at the next refresh.
+type Service struct {
+	fetcher *Fetcher
+	cfg     Config
+	queue   chan job
+
+	// mu guards closed and pending; pending holds the queued link IDs so a
+	// link is never queued twice
+	mu      sync.Mutex
+	closed  bool
+	pending map[uint]bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewService creates a service; call Start to launch its workers
+func NewService(fetcher *Fetcher, cfg Config) *Service {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 2
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.RefreshBatch <= 0 {
+		cfg.RefreshBatch = 100
+	}
+
+	return &Service{
+		fetcher: fetcher,
+		cfg:     cfg,
+		queue:   make(chan job, cfg.QueueSize),
+		pending: make(map[uint]bool),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start launches the workers and the refresh loop
+func (s *Service) Start() {
+	for i := 0; i < s.cfg.Workers; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	if s.cfg.RefreshInterval > 0 {
+		s.wg.Add(1)
+		go s.refreshLoop()
+	}
+}
+
+// Enqueue queues a link for fetching without waiting. It reports false when
+// the link was not queued; links missed here are picked up by the refresh
+// loop. A nil service queues nothing.
+func (s *Service) Enqueue(id uint, rawURL string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.pending[id] {
+		return false
+	}
+	select {
+	case s.queue <- job{id: id, url: rawURL}:
+		s.pending[id] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop stops the refresh loop and waits for in-flight fetches, dropping
+// queued ones, giving up when ctx is done
+func (s *Service) Stop(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Service) worker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case j := <-s.queue:
+			s.fetch(j)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// fetch reads one link's metadata and stores it
+func (s *Service) fetch(j job) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, j.id)
+		s.mu.Unlock()
+	}()
+
+	ctx := context.Background()
+	meta, err := s.fetcher.Fetch(ctx, j.url)
+	now := time.Now()
+	if err != nil {
+		log.Printf("Failed to fetch metadata of %s: %v", j.url, err)
+		err = store.Links.UpdateMetadata(ctx, j.id, nil, now)
+	} else {
+		err = store.Links.UpdateMetadata(ctx, j.id, &meta, now)
+	}
+	if err != nil && err != store.ErrNotFound {
+		log.Printf("Failed to store metadata of link %d: %v", j.id, err)
+	}
+}
+
+// refreshLoop queues links whose metadata is missing or out of date
+func (s *Service) refreshLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(refreshScanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			stale, err := store.Links.StaleMetadata(context.Background(), time.Now().Add(-s.cfg.RefreshInterval), s.cfg.RefreshBatch)
+			if err != nil {
+				log.Printf("Failed to look up stale link metadata: %v", err)
+				continue
+			}
+			for _, url := range stale {
+				s.Enqueue(url.ID, url.OriginalURL)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Default fetches the metadata of every link; it is nil when fetching is
+// disabled
+var Default *Service
+
+// Init creates the default service from the environment and starts it,
+// unless METADATA_FETCH is false. Destinations in private networks are only
+// fetched when METADATA_ALLOW_PRIVATE is true.
+func Init() {
+	if config.GetEnv("METADATA_FETCH", "true") == "false" {
+		log.Println("Link metadata fetching disabled")
+		return
+	}
+
+	client := NewPublicClient()
+	if config.GetEnv("METADATA_ALLOW_PRIVATE", "false") == "true" {
+		client = &http.Client{}
+	}
+
+	fetcher := NewFetcher(
+		client,
+		config.GetEnv("METADATA_USER_AGENT", "ShorterBot/1.0 (link preview)"),
+		int64(config.GetEnvInt("METADATA_MAX_BYTES", 512*1024)),
+		config.GetEnvDuration("METADATA_TIMEOUT", 10*time.Second),
+	)
+	Default = NewService(fetcher, Config{
+		Workers:         config.GetEnvInt("METADATA_WORKERS", 2),
+		QueueSize:       config.GetEnvInt("METADATA_QUEUE_SIZE", 1000),
+		RefreshInterval: config.GetEnvDuration("METADATA_REFRESH_INTERVAL", 24*time.Hour),
+		RefreshBatch:    config.GetEnvInt("METADATA_REFRESH_BATCH", 100),
+	})
+	Default.Start()
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"shorter-backend/models"
+	"shorter-backend/store"
+	"shorter-backend/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	userContextKey   = "user"
+	apiKeyContextKey = "api_key"
+
+	// lastUsedResolution limits how often last_used_at is written per key
+	lastUsedResolution = time.Minute
+)
+
+// Authenticate resolves the API key sent with the request, if any, and
+// stores the owning user on the context. Requests without a key pass
+// through anonymously; requests with an invalid or revoked key are rejected.
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := extractAPIKey(c.Request)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+
+		apiKey, err := store.Users.FindAPIKeyByHash(ctx, utils.HashAPIKey(key))
+		if err != nil || apiKey.RevokedAt != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			c.Abort()
+			return
+		}
+
+		user, err := store.Users.FindByID(ctx, apiKey.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			c.Abort()
+			return
+		}
+
+		now := time.Now()
+		if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > lastUsedResolution {
+			store.Users.TouchAPIKey(ctx, apiKey, now)
+		}
+
+		c.Set(userContextKey, user)
+		c.Set(apiKeyContextKey, apiKey)
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests that are anonymous or whose API key was not
+// granted the given scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := CurrentAPIKey(c)
+		if apiKey == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+			c.Abort()
+			return
+		}
+		if !apiKey.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing scope " + scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// OptionalScope lets anonymous requests through but requires authenticated
+// ones to carry the given scope
+func OptionalScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := CurrentAPIKey(c); apiKey != nil && !apiKey.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key is missing scope " + scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRole rejects requests from anonymous callers and from users below
+// the given role
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+			c.Abort()
+			return
+		}
+		if !user.HasRole(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Requires " + role + " role"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentUser returns the authenticated user, or nil for anonymous requests
+func CurrentUser(c *gin.Context) *models.User {
+	if value, exists := c.Get(userContextKey); exists {
+		return value.(*models.User)
+	}
+	return nil
+}
+
+// CurrentAPIKey returns the API key used for the request, or nil
+func CurrentAPIKey(c *gin.Context) *models.APIKey {
+	if value, exists := c.Get(apiKeyContextKey); exists {
+		return value.(*models.APIKey)
+	}
+	return nil
+}
+
+// extractAPIKey reads the key from the Authorization or X-API-Key header
+func extractAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+			return strings.TrimSpace(auth[len("bearer "):])
+		}
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
@@ -0,0 +1,378 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"shorter-backend/models"
+	"shorter-backend/utils"
+)
+
+// Actions limited by quota plans
+const (
+	ActionRequests = "requests"
+	ActionLinks    = "links"
+	ActionQR       = "qr"
+)
+
+// actionNouns names what each action counts, for error messages
+var actionNouns = map[string]string{
+	ActionRequests: "requests",
+	ActionLinks:    "links",
+	ActionQR:       "QR codes",
+}
+
+// AnonymousPlan applies to requests made without an API key
+const AnonymousPlan = "anonymous"
+
+// Limit bounds one action of a plan. PerMinute is a rate limit that refills
+// continuously; PerDay and PerMonth are quotas over calendar days and months
+// in UTC. Zero means unlimited.
+type Limit struct {
+	PerMinute int   `json:"per_minute"`
+	PerDay    int64 `json:"per_day"`
+	PerMonth  int64 `json:"per_month"`
+}
+
+// Plan maps actions to their limits; actions it leaves out are unlimited
+type Plan map[string]Limit
+
+// builtinPlans apply unless PLANS_FILE replaces them
+var builtinPlans = map[string]Plan{
+	AnonymousPlan: {
+		ActionRequests: {PerMinute: 100},
+		ActionLinks:    {PerMinute: 10},
+		ActionQR:       {PerMinute: 20},
+	},
+	"free": {
+		ActionRequests: {PerMinute: 100},
+		ActionLinks:    {PerMinute: 10, PerDay: 100, PerMonth: 1000},
+		ActionQR:       {PerMinute: 20},
+	},
+	"pro": {
+		ActionRequests: {PerMinute: 1000},
+		ActionLinks:    {PerMinute: 100, PerDay: 10000, PerMonth: 100000},
+		ActionQR:       {PerMinute: 200},
+	},
+}
+
+// Quota plans and their counters, built by InitLimiters
+var (
+	plans       map[string]Plan
+	defaultPlan string
+
+	// planLimiters holds the per-minute limiter of each plan and action
+	planLimiters map[string]map[string]Limiter
+
+	quotaCounter QuotaCounter
+)
+
+// loadPlans returns the built-in plans, with those defined in the JSON file
+// at path (if any) added or replacing the built-in plan of the same name,
+// e.g. {"free": {"links": {"per_minute": 10, "per_day": 100}}}
+func loadPlans(path string) (map[string]Plan, error) {
+	loaded := make(map[string]Plan, len(builtinPlans))
+	for name, plan := range builtinPlans {
+		loaded[name] = plan
+	}
+	if path == "" {
+		return loaded, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var custom map[string]Plan
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, plan := range custom {
+		for action, limit := range plan {
+			if _, ok := actionNouns[action]; !ok {
+				return nil, fmt.Errorf("plan %s: unknown action %q", name, action)
+			}
+			if limit.PerMinute < 0 || limit.PerDay < 0 || limit.PerMonth < 0 {
+				return nil, fmt.Errorf("plan %s: negative limit for %s", name, action)
+			}
+		}
+		loaded[name] = plan
+	}
+	return loaded, nil
+}
+
+// IsValidPlan reports whether name is a plan users can be put on
+func IsValidPlan(name string) bool {
+	_, ok := plans[name]
+	return ok && name != AnonymousPlan
+}
+
+// PlanName returns the plan that applies to user: the anonymous plan when
+// there is no user, and the default plan for users without a known plan
+func PlanName(user *models.User) string {
+	if user == nil {
+		return AnonymousPlan
+	}
+	if IsValidPlan(user.Plan) {
+		return user.Plan
+	}
+	return defaultPlan
+}
+
+// quotaWindow is a calendar period quotas are counted over
+type quotaWindow struct {
+	name  string
+	label string
+	limit func(Limit) int64
+	start func(time.Time) time.Time
+	next  func(time.Time) time.Time
+}
+
+var quotaWindows = []quotaWindow{
+	{
+		name:  "day",
+		label: "Daily",
+		limit: func(l Limit) int64 { return l.PerDay },
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	},
+	{
+		name:  "month",
+		label: "Monthly",
+		limit: func(l Limit) int64 { return l.PerMonth },
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	},
+}
+
+// quotaKey names the counter of one action in the window starting at start
+func quotaKey(action string, w quotaWindow, identity string, start time.Time) string {
+	return "quota:" + action + ":" + w.name + ":" + identity + ":" + start.Format("20060102")
+}
+
+// quotaIdentity keys the rate limits and quotas: all keys of a user share
+// them, so minting more keys doesn't raise a user's allowance
+func quotaIdentity(c *gin.Context) string {
+	if user := CurrentUser(c); user != nil {
+		return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+	}
+	return "ip:" + utils.GetClientIP(c.Request)
+}
+
+// Quota enforces the caller's plan on action: the per-minute rate limit
+// first, then the daily and monthly quotas. Both are counted per user;
+// anonymous requests are counted by IP address.
+// Requests that fail are given back to the quotas.
+func Quota(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		planName := PlanName(CurrentUser(c))
+		limit := plans[planName][action]
+
+		identity := quotaIdentity(c)
+		if limiter := planLimiters[planName][action]; limiter != nil {
+			result := limiter.Allow(identity)
+			setRateLimitHeaders(c, result)
+			if !result.Allowed {
+				tooManyRequests(c, "Rate limit exceeded. Please try again later.", result.RetryAfter)
+				return
+			}
+		}
+
+		now := time.Now().UTC()
+		var taken []string
+		refund := func() {
+			for _, key := range taken {
+				quotaCounter.Refund(key)
+			}
+		}
+
+		for _, w := range quotaWindows {
+			allowance := w.limit(limit)
+			if allowance == 0 {
+				continue
+			}
+			start := w.start(now)
+			reset := w.next(start)
+			key := quotaKey(action, w, identity, start)
+
+			count, ok := quotaCounter.Take(key, allowance, reset)
+			result := Result{
+				Allowed:   ok,
+				Limit:     int(allowance),
+				Remaining: int(max(allowance-count, 0)),
+				Reset:     reset.Sub(now),
+			}
+			if !ok {
+				result.RetryAfter = result.Reset
+			}
+			setRateLimitHeaders(c, result)
+
+			if !ok {
+				refund()
+				tooManyRequests(c, fmt.Sprintf("%s quota of %d %s exceeded", w.label, allowance, actionNouns[action]), result.RetryAfter)
+				return
+			}
+			taken = append(taken, key)
+		}
+
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest {
+			refund()
+		}
+	}
+}
+
+// Usage reports the caller's plan, its limits and how much of each quota
+// has been used in the current windows
+func Usage(c *gin.Context) models.UsageResponse {
+	planName := PlanName(CurrentUser(c))
+	identity := quotaIdentity(c)
+	now := time.Now().UTC()
+
+	actions := make(map[string]models.ActionUsage, len(plans[planName]))
+	for action, limit := range plans[planName] {
+		usage := models.ActionUsage{PerMinute: limit.PerMinute}
+		for _, w := range quotaWindows {
+			allowance := w.limit(limit)
+			if allowance == 0 {
+				continue
+			}
+			start := w.start(now)
+			used := quotaCounter.Count(quotaKey(action, w, identity, start))
+			quota := &models.QuotaUsage{
+				Limit:     allowance,
+				Used:      used,
+				Remaining: max(allowance-used, 0),
+				ResetsAt:  w.next(start),
+			}
+			if w.name == "day" {
+				usage.Day = quota
+			} else {
+				usage.Month = quota
+			}
+		}
+		actions[action] = usage
+	}
+
+	return models.UsageResponse{Plan: planName, Actions: actions}
+}
+
+// QuotaCounter counts the uses of actions within quota windows
+type QuotaCounter interface {
+	// Take records a use under key unless limit uses are already recorded,
+	// returning the count after it. The count is dropped at expires.
+	Take(key string, limit int64, expires time.Time) (int64, bool)
+	// Refund gives back a use recorded under key
+	Refund(key string)
+	// Count returns the uses recorded under key
+	Count(key string) int64
+	// Stop releases background resources
+	Stop()
+}
+
+// MemoryQuotaCounter is the in-memory QuotaCounter; counts are per instance
+// and lost on restart
+type MemoryQuotaCounter struct {
+	counts map[string]*quotaCount
+	mutex  sync.Mutex
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type quotaCount struct {
+	n       int64
+	expires time.Time
+}
+
+// NewMemoryQuotaCounter creates an in-memory quota counter
+func NewMemoryQuotaCounter() *MemoryQuotaCounter {
+	qc := &MemoryQuotaCounter{
+		counts: make(map[string]*quotaCount),
+		stop:   make(chan struct{}),
+	}
+	go qc.cleanupRoutine()
+	return qc
+}
+
+// live returns the unexpired count under key, if any
+func (qc *MemoryQuotaCounter) live(key string, now time.Time) *quotaCount {
+	count, ok := qc.counts[key]
+	if !ok || !now.Before(count.expires) {
+		return nil
+	}
+	return count
+}
+
+func (qc *MemoryQuotaCounter) Take(key string, limit int64, expires time.Time) (int64, bool) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	count := qc.live(key, time.Now())
+	if count == nil {
+		count = &quotaCount{expires: expires}
+		qc.counts[key] = count
+	}
+	if count.n >= limit {
+		return count.n, false
+	}
+	count.n++
+	return count.n, true
+}
+
+func (qc *MemoryQuotaCounter) Refund(key string) {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	if count := qc.live(key, time.Now()); count != nil && count.n > 0 {
+		count.n--
+	}
+}
+
+func (qc *MemoryQuotaCounter) Count(key string) int64 {
+	qc.mutex.Lock()
+	defer qc.mutex.Unlock()
+
+	if count := qc.live(key, time.Now()); count != nil {
+		return count.n
+	}
+	return 0
+}
+
+// cleanupRoutine drops the counts of windows that have ended
+func (qc *MemoryQuotaCounter) cleanupRoutine() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			qc.mutex.Lock()
+			now := time.Now()
+			for key, count := range qc.counts {
+				if !now.Before(count.expires) {
+					delete(qc.counts, key)
+				}
+			}
+			qc.mutex.Unlock()
+		case <-qc.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the cleanup routine
+func (qc *MemoryQuotaCounter) Stop() {
+	qc.once.Do(func() { close(qc.stop) })
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shorter-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usePlans installs plans with in-memory limiters and counters for a test
+func usePlans(t *testing.T, test map[string]Plan) {
+	t.Helper()
+	plans, defaultPlan = test, "free"
+	planLimiters = make(map[string]map[string]Limiter)
+	for name, plan := range test {
+		planLimiters[name] = make(map[string]Limiter)
+		for action, limit := range plan {
+			if limit.PerMinute > 0 {
+				planLimiters[name][action] = NewRateLimiter(time.Minute/time.Duration(limit.PerMinute), limit.PerMinute)
+			}
+		}
+	}
+	quotaCounter = NewMemoryQuotaCounter()
+	t.Cleanup(func() {
+		for _, limiters := range planLimiters {
+			for _, limiter := range limiters {
+				limiter.Stop()
+			}
+		}
+		quotaCounter.Stop()
+	})
+}
+
+func TestQuotaRateLimitSharedByUserKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	usePlans(t, map[string]Plan{
+		"free": {ActionRequests: {PerMinute: 2}},
+	})
+
+	user := &models.User{ID: 7, Plan: "free"}
+	router := gin.New()
+	router.GET("/api/:key", func(c *gin.Context) {
+		// Each request authenticates with one of the user's keys
+		c.Set(userContextKey, user)
+		c.Set(apiKeyContextKey, &models.APIKey{ID: uint(len(c.Param("key")))})
+	}, Quota(ActionRequests), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i, path := range []string{"/api/a", "/api/bb", "/api/ccc", "/api/dddd"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want[i] {
+			t.Errorf("request %d with a new key = %d, want %d", i+1, w.Code, want[i])
+		}
+	}
+}
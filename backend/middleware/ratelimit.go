@@ -1,26 +1,69 @@
 package middleware
 
 import (
-	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"shorter-backend/config"
 	"shorter-backend/utils"
 )
 
-// RateLimiter holds the rate limiting configuration
+// Limiter decides whether a client may make another request. Each client
+// earns one request per interval, up to a burst.
+type Limiter interface {
+	// Allow takes one request from the client's allowance
+	Allow(key string) Result
+	// Stop releases background resources
+	Stop()
+}
+
+// Result is the outcome of a rate limit check and the client's allowance
+// after it
+type Result struct {
+	Allowed bool
+	// Limit is the burst size
+	Limit int
+	// Remaining is the number of whole requests left right now
+	Remaining int
+	// Reset is the time until the allowance is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed; zero while
+	// requests remain
+	RetryAfter time.Duration
+}
+
+// bucketResult describes a token bucket holding tokens after a request
+func bucketResult(allowed bool, tokens float64, rate time.Duration, burst int) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(burst) - tokens) * float64(rate)),
+	}
+	if tokens < 1 {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(rate))
+	}
+	return result
+}
+
+// RateLimiter is the in-memory Limiter; every instance counts on its own
 type RateLimiter struct {
 	clients map[string]*ClientLimiter
 	mutex   sync.RWMutex
 	rate    time.Duration
 	burst   int
+	stop    chan struct{}
+	once    sync.Once
 }
 
 // ClientLimiter holds individual client rate limiting data
 type ClientLimiter struct {
-	tokens   int
+	tokens   float64
 	lastSeen time.Time
 }
 
@@ -30,6 +73,7 @@ func NewRateLimiter(rate time.Duration, burst int) *RateLimiter {
 		clients: make(map[string]*ClientLimiter),
 		rate:    rate,
 		burst:   burst,
+		stop:    make(chan struct{}),
 	}
 	
 	// Clean up old clients every minute
@@ -38,8 +82,9 @@ func NewRateLimiter(rate time.Duration, burst int) *RateLimiter {
 	return rl
 }
 
-// Allow checks if the request should be allowed
-func (rl *RateLimiter) Allow(clientIP string) bool {
+// Allow takes one request from the client's allowance. Tokens accrue
+// continuously, so partial refills carry over between requests.
+func (rl *RateLimiter) Allow(clientIP string) Result {
 	rl.mutex.Lock()
 	defer rl.mutex.Unlock()
 	
@@ -48,31 +93,23 @@ func (rl *RateLimiter) Allow(clientIP string) bool {
 	client, exists := rl.clients[clientIP]
 	if !exists {
 		client = &ClientLimiter{
-			tokens:   rl.burst - 1, // Use one token
+			tokens:   float64(rl.burst),
 			lastSeen: now,
 		}
 		rl.clients[clientIP] = client
-		return true
 	}
 	
-	// Calculate tokens to add based on time passed
+	// Add the tokens earned since the last request, fractions included
 	elapsed := now.Sub(client.lastSeen)
-	tokensToAdd := int(elapsed / rl.rate)
-	
-	if tokensToAdd > 0 {
-		client.tokens += tokensToAdd
-		if client.tokens > rl.burst {
-			client.tokens = rl.burst
-		}
-		client.lastSeen = now
-	}
+	client.tokens = math.Min(float64(rl.burst), client.tokens+float64(elapsed)/float64(rl.rate))
+	client.lastSeen = now
 	
-	if client.tokens > 0 {
+	allowed := client.tokens >= 1
+	if allowed {
 		client.tokens--
-		return true
 	}
 	
-	return false
+	return bucketResult(allowed, client.tokens, rl.rate, rl.burst)
 }
 
 // cleanupRoutine removes old clients to prevent memory leaks
@@ -80,29 +117,76 @@ func (rl *RateLimiter) cleanupRoutine() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 	
-	for range ticker.C {
-		rl.mutex.Lock()
-		now := time.Now()
-		for ip, client := range rl.clients {
-			if now.Sub(client.lastSeen) > time.Hour {
-				delete(rl.clients, ip)
+	for {
+		select {
+		case <-ticker.C:
+			rl.mutex.Lock()
+			now := time.Now()
+			for ip, client := range rl.clients {
+				if now.Sub(client.lastSeen) > time.Hour {
+					delete(rl.clients, ip)
+				}
 			}
+			rl.mutex.Unlock()
+		case <-rl.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the cleanup routine
+func (rl *RateLimiter) Stop() {
+	rl.once.Do(func() { close(rl.stop) })
+}
+
+// rateLimitContextKey holds the Result reported in the response headers
+const rateLimitContextKey = "rateLimit"
+
+// ceilSeconds rounds a duration up to whole seconds for headers
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// setRateLimitHeaders reports result in the RateLimit-* headers, and in
+// Retry-After once no requests remain. When several limiters apply to a
+// route the one with the least allowance left is reported.
+func setRateLimitHeaders(c *gin.Context, result Result) {
+	if previous, ok := c.Get(rateLimitContextKey); ok {
+		if prev := previous.(Result); result.Allowed && prev.Remaining <= result.Remaining {
+			return
 		}
-		rl.mutex.Unlock()
 	}
+	c.Set(rateLimitContextKey, result)
+	
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	if result.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	} else {
+		c.Writer.Header().Del("Retry-After")
+	}
+}
+
+// tooManyRequests rejects the request with the time to wait before retrying
+func tooManyRequests(c *gin.Context, message string, retryAfter time.Duration) {
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"retry_after": ceilSeconds(retryAfter),
+	})
+	c.Abort()
 }
 
-// RateLimitMiddleware returns a Gin middleware for rate limiting
-func RateLimitMiddleware(rl *RateLimiter) gin.HandlerFunc {
+// RateLimitMiddleware returns a Gin middleware limiting each client IP
+func RateLimitMiddleware(rl Limiter) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		clientIP := utils.GetClientIP(c.Request)
 		
-		if !rl.Allow(clientIP) {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Rate limit exceeded. Please try again later.",
-				"retry_after": fmt.Sprintf("%.0f", rl.rate.Seconds()),
-			})
-			c.Abort()
+		result := rl.Allow(clientIP)
+		setRateLimitHeaders(c, result)
+		
+		if !result.Allowed {
+			tooManyRequests(c, "Rate limit exceeded. Please try again later.", result.RetryAfter)
 			return
 		}
 		
@@ -110,14 +194,84 @@ func RateLimitMiddleware(rl *RateLimiter) gin.HandlerFunc {
 	}
 }
 
-// Create pre-configured rate limiters
+// Pre-configured IP rate limiters for routes outside the quota plans, built
+// by InitLimiters
 var (
-	// General API rate limiter: 100 requests per minute
-	GeneralLimiter = NewRateLimiter(600*time.Millisecond, 100)
-	
-	// URL creation rate limiter: 10 URLs per minute (more restrictive)
-	CreateURLLimiter = NewRateLimiter(6*time.Second, 10)
+	// Account registration limiter: 10 sign-ups, then one every 6 seconds
+	SignupLimiter Limiter
 	
-	// QR code generation limiter: 20 QR codes per minute
-	QRCodeLimiter = NewRateLimiter(3*time.Second, 20)
-) 
\ No newline at end of file
+	// Password attempt limiter: 5 attempts, then one every 12 seconds
+	PasswordLimiter Limiter
+
+	// Conversion report limiter: 20 reports, then one every 3 seconds
+	ConversionLimiter Limiter
+)
+
+// InitLimiters builds the pre-configured limiters and the quota plans on
+// the backend named by RATE_LIMIT_BACKEND: "memory" (the default) or
+// "redis", which shares the limits and quotas between instances. Redis
+// falls back to memory when it is not connected.
+//
+// Plans come from PLANS_FILE on top of the built-in anonymous, free and pro
+// plans; users without a plan are on DEFAULT_PLAN.
+func InitLimiters() {
+	backend := config.GetEnv("RATE_LIMIT_BACKEND", "memory")
+	if backend != "memory" && backend != "redis" {
+		log.Fatalf("Unknown RATE_LIMIT_BACKEND %q (expected memory or redis)", backend)
+	}
+	if backend == "redis" && config.RDB == nil {
+		log.Println("Redis unavailable, rate limiting in memory")
+		backend = "memory"
+	}
+
+	newLimiter := func(name string, rate time.Duration, burst int) Limiter {
+		if backend == "redis" {
+			return NewRedisLimiter(config.RDB, name, rate, burst)
+		}
+		return NewRateLimiter(rate, burst)
+	}
+
+	SignupLimiter = newLimiter("signup", 6*time.Second, 10)
+	PasswordLimiter = newLimiter("password", 12*time.Second, 5)
+	ConversionLimiter = newLimiter("conversion", 3*time.Second, 20)
+
+	loaded, err := loadPlans(config.GetEnv("PLANS_FILE", ""))
+	if err != nil {
+		log.Fatalf("Failed to load quota plans: %v", err)
+	}
+	plans = loaded
+	defaultPlan = config.GetEnv("DEFAULT_PLAN", "free")
+	if !IsValidPlan(defaultPlan) {
+		log.Fatalf("DEFAULT_PLAN %q is not a configured plan", defaultPlan)
+	}
+
+	planLimiters = make(map[string]map[string]Limiter, len(plans))
+	for name, plan := range plans {
+		planLimiters[name] = make(map[string]Limiter)
+		for action, limit := range plan {
+			if limit.PerMinute > 0 {
+				planLimiters[name][action] = newLimiter(name+":"+action, time.Minute/time.Duration(limit.PerMinute), limit.PerMinute)
+			}
+		}
+	}
+
+	if backend == "redis" {
+		quotaCounter = NewRedisQuotaCounter(config.RDB)
+	} else {
+		quotaCounter = NewMemoryQuotaCounter()
+	}
+}
+
+// StopLimiters stops the cleanup routines of the pre-configured limiters
+// and quota counters
+func StopLimiters() {
+	SignupLimiter.Stop()
+	PasswordLimiter.Stop()
+	ConversionLimiter.Stop()
+	for _, limiters := range planLimiters {
+		for _, limiter := range limiters {
+			limiter.Stop()
+		}
+	}
+	quotaCounter.Stop()
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucketScript refills and takes from a bucket in one atomic step so
+// concurrent instances can't both spend the last token.
+//
+// KEYS[1] bucket hash; ARGV: now (µs), refill interval (µs), burst
+// Returns {allowed (1 or 0), tokens left in thousandths}; Lua numbers
+// returned to Redis are truncated to integers.
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+-- Instance clocks may disagree slightly; never refill backwards
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) / interval)
+	ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+-- A bucket left alone for this long is full again and can be forgotten
+redis.call('PEXPIRE', KEYS[1], math.max(1, math.ceil(interval * burst / 1000)))
+return {allowed, math.floor(tokens * 1000)}
+`)
+
+// RedisLimiter is a Limiter whose buckets live in Redis, so every instance
+// draws from the same allowance and limits survive restarts
+type RedisLimiter struct {
+	rdb   *redis.Client
+	name  string
+	rate  time.Duration
+	burst int
+}
+
+// NewRedisLimiter creates a limiter; name keeps the buckets of different
+// limiters apart. Buckets refill in whole microseconds, so shorter rates
+// are rounded up to one.
+func NewRedisLimiter(rdb *redis.Client, name string, rate time.Duration, burst int) *RedisLimiter {
+	if rate < time.Microsecond {
+		rate = time.Microsecond
+	}
+	return &RedisLimiter{rdb: rdb, name: name, rate: rate, burst: burst}
+}
+
+// Allow takes one request from the client's allowance. When Redis can't be
+// reached the request is let through rather than failing the API.
+func (rl *RedisLimiter) Allow(key string) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := tokenBucketScript.Run(ctx, rl.rdb,
+		[]string{"ratelimit:" + rl.name + ":" + key},
+		time.Now().UnixMicro(), rl.rate.Microseconds(), rl.burst,
+	).Int64Slice()
+	if err == nil && len(reply) != 2 {
+		err = fmt.Errorf("unexpected reply %v", reply)
+	}
+	if err != nil {
+		log.Printf("Rate limiter %s unavailable, allowing request: %v", rl.name, err)
+		return Result{Allowed: true, Limit: rl.burst, Remaining: rl.burst}
+	}
+
+	return bucketResult(reply[0] == 1, float64(reply[1])/1000, rl.rate, rl.burst)
+}
+
+// Stop is a no-op; the buckets expire on their own
+func (rl *RedisLimiter) Stop() {}
+
+// quotaTakeScript counts a use unless the limit has been reached.
+//
+// KEYS[1] counter; ARGV: limit, expiry (unix ms)
+// Returns {taken (1 or 0), count after}
+var quotaTakeScript = redis.NewScript(`
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if count >= tonumber(ARGV[1]) then
+	return {0, count}
+end
+count = redis.call('INCR', KEYS[1])
+redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+return {1, count}
+`)
+
+// quotaRefundScript gives back a use without recreating an expired counter
+var quotaRefundScript = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[1]) or '0') > 0 then
+	return redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+// RedisQuotaCounter is a QuotaCounter shared by every instance. When Redis
+// can't be reached uses are let through rather than failing the API.
+type RedisQuotaCounter struct {
+	rdb *redis.Client
+}
+
+// NewRedisQuotaCounter creates a quota counter on rdb
+func NewRedisQuotaCounter(rdb *redis.Client) *RedisQuotaCounter {
+	return &RedisQuotaCounter{rdb: rdb}
+}
+
+func (qc *RedisQuotaCounter) Take(key string, limit int64, expires time.Time) (int64, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := quotaTakeScript.Run(ctx, qc.rdb, []string{key}, limit, expires.UnixMilli()).Int64Slice()
+	if err == nil && len(reply) != 2 {
+		err = fmt.Errorf("unexpected reply %v", reply)
+	}
+	if err != nil {
+		log.Printf("Quota counter unavailable, allowing request: %v", err)
+		return 0, true
+	}
+	return reply[1], reply[0] == 1
+}
+
+func (qc *RedisQuotaCounter) Refund(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := quotaRefundScript.Run(ctx, qc.rdb, []string{key}).Err(); err != nil {
+		log.Printf("Failed to refund quota %s: %v", key, err)
+	}
+}
+
+func (qc *RedisQuotaCounter) Count(key string) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	count, err := qc.rdb.Get(ctx, key).Int64()
+	if err != nil && err != redis.Nil {
+		log.Printf("Failed to read quota %s: %v", key, err)
+	}
+	return count
+}
+
+// Stop is a no-op; the counters expire on their own
+func (qc *RedisQuotaCounter) Stop() {}
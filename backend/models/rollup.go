@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Bucket granularities. Rollups are stored by hour and by day; weeks and
+// months are charted from the daily rollups.
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+)
+
+// ClickRollup is a pre-aggregated click count for one link, time bucket and
+// dimension value. Rows with an empty Dimension hold the bucket's totals;
+// only those carry a unique visitor count. Buckets start on UTC boundaries.
+type ClickRollup struct {
+	URLId       uint      `json:"url_id" gorm:"primaryKey;autoIncrement:false"`
+	Granularity string    `json:"granularity" gorm:"primaryKey;size:8;index:idx_click_rollups_bucket,priority:1"`
+	Bucket      time.Time `json:"bucket" gorm:"primaryKey;index:idx_click_rollups_bucket,priority:3"`
+	Dimension   string    `json:"dimension" gorm:"primaryKey;size:16;index:idx_click_rollups_bucket,priority:2"`
+	Value       string    `json:"value" gorm:"primaryKey"`
+	Clicks      int64     `json:"clicks" gorm:"not null;default:0"`
+	BotClicks   int64     `json:"bot_clicks" gorm:"not null;default:0"`
+	Uniques     int64     `json:"uniques" gorm:"not null;default:0"`
+}
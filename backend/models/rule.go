@@ -0,0 +1,77 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Rule sends the visitors matching all of its conditions to its own
+// destination. A condition left empty matches everyone; otherwise the
+// visitor must match one of its values.
+type Rule struct {
+	// Name identifies the rule on the clicks it routed
+	Name string `json:"name"`
+	// Countries are ISO 3166-1 alpha-2 codes, e.g. "ID"
+	Countries []string `json:"countries,omitempty"`
+	// Regions are ISO 3166-2 codes, e.g. "ID-JK"
+	Regions []string `json:"regions,omitempty"`
+	// Languages are language ranges matched against the visitor's preferred
+	// language, e.g. "id" or "en-US"
+	Languages []string `json:"languages,omitempty"`
+	// Devices are device classes: desktop, mobile, tablet or bot
+	Devices []string `json:"devices,omitempty"`
+	// OS are operating systems as reported in click statistics, e.g. "iOS"
+	// or "Android"
+	OS []string `json:"os,omitempty"`
+	// URL may use an app's own scheme, e.g. an Android intent:// link
+	URL string `json:"url"`
+}
+
+// Rules are a link's destination rules, tried in order; the first match
+// wins and visitors matching none go to the link's own destination. They
+// are stored as JSON, with no rules stored as an empty string.
+type Rules []Rule
+
+// Value implements driver.Valuer
+func (r Rules) Value() (driver.Value, error) {
+	return jsonColumnValue(r, len(r) == 0)
+}
+
+// Scan implements sql.Scanner
+func (r *Rules) Scan(value interface{}) error {
+	*r = nil
+	return scanJSONColumn(value, r)
+}
+
+// jsonColumnValue stores v as JSON, or as an empty string when empty
+func jsonColumnValue(v interface{}, empty bool) (driver.Value, error) {
+	if empty {
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// scanJSONColumn reads a column written by jsonColumnValue into dest,
+// leaving it untouched when the column is empty
+func scanJSONColumn(value interface{}, dest interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("cannot scan %T into %T", value, dest)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, dest)
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 	"gorm.io/gorm"
 )
@@ -10,6 +11,21 @@ type URL struct {
 	OriginalURL string         `json:"original_url" gorm:"not null;index"`
 	ShortCode   string         `json:"short_code" gorm:"uniqueIndex;not null"`
 	Title       string         `json:"title"`
+	UserID      *uint          `json:"user_id,omitempty" gorm:"index"`
+	ExpiresAt   *time.Time     `json:"expires_at,omitempty" gorm:"index"`
+	MaxClicks   int64          `json:"max_clicks,omitempty" gorm:"not null;default:0"`
+	UsedClicks  int64          `json:"-" gorm:"not null;default:0"`
+	PasswordHash string        `json:"-" gorm:"not null;default:''"`
+	RedirectStatus int         `json:"redirect_status" gorm:"not null;default:302"`
+	ForwardQuery string        `json:"forward_query,omitempty" gorm:"not null;default:''"`
+	ForwardPath bool           `json:"forward_path" gorm:"not null;default:false"`
+	Rules       Rules          `json:"rules,omitempty" gorm:"type:text;not null;default:''"`
+	Variants    Variants       `json:"variants,omitempty" gorm:"type:text;not null;default:''"`
+	Description string         `json:"description,omitempty"`
+	ImageURL    string         `json:"image_url,omitempty"`
+	SiteName    string         `json:"site_name,omitempty"`
+	PageTitle   string         `json:"-"`
+	MetadataFetchedAt *time.Time `json:"metadata_fetched_at,omitempty" gorm:"index"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
@@ -17,14 +33,63 @@ type URL struct {
 	ClickCount  int64          `json:"click_count" gorm:"-"`
 }
 
+// DefaultRedirectStatus is used by links that don't choose one. Browsers
+// don't cache a 302 Found, so edits take effect and every visit is counted.
+const DefaultRedirectStatus = 302
+
+// RedirectCode returns the status code visitors are redirected with
+func (u *URL) RedirectCode() int {
+	if u.RedirectStatus == 0 {
+		return DefaultRedirectStatus
+	}
+	return u.RedirectStatus
+}
+
+// How a link forwards the query string of the short URL to its
+// destination. When a parameter is set on both, preserve keeps the
+// destination's values, override replaces them with the incoming ones and
+// append keeps both.
+const (
+	ForwardQueryNone     = ""
+	ForwardQueryPreserve = "preserve"
+	ForwardQueryOverride = "override"
+	ForwardQueryAppend   = "append"
+)
+
+// IsExpired reports whether the link is past its expiry date
+func (u *URL) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+// PageMetadata is what a destination page says about itself in its title
+// and Open Graph or Twitter card tags
+type PageMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
 type Click struct {
 	ID        uint           `json:"id" gorm:"primaryKey"`
 	URLId     uint           `json:"url_id" gorm:"not null;index"`
 	IPAddress string         `json:"ip_address"`
 	UserAgent string         `json:"user_agent"`
 	Referer   string         `json:"referer"`
-	Country   string         `json:"country"`
+	Country   string         `json:"country" gorm:"index"`
+	Region    string         `json:"region"`
 	City      string         `json:"city"`
+	ASN       uint           `json:"asn"`
+	ASOrg     string         `json:"as_org"`
+	Browser        string    `json:"browser"`
+	BrowserVersion string    `json:"browser_version"`
+	OS        string         `json:"os"`
+	Device    string         `json:"device" gorm:"index"`
+	IsBot     bool           `json:"is_bot" gorm:"not null;default:false;index"`
+	BotReason string         `json:"bot_reason,omitempty"`
+	Rule      string         `json:"rule,omitempty" gorm:"size:64"`
+	Variant   string         `json:"variant,omitempty" gorm:"size:64"`
+	VisitorID string         `json:"-" gorm:"size:32"`
 	CreatedAt time.Time      `json:"created_at"`
 	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
@@ -32,6 +97,43 @@ type Click struct {
 type CreateURLRequest struct {
 	URL       string `json:"url" binding:"required,url"`
 	CustomCode string `json:"custom_code,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	MaxClicks  int64      `json:"max_clicks,omitempty"`
+	Password   string     `json:"password,omitempty"`
+	RedirectStatus int    `json:"redirect_status,omitempty"`
+	ForwardQuery string   `json:"forward_query,omitempty"`
+	ForwardPath  bool     `json:"forward_path,omitempty"`
+	Rules        Rules    `json:"rules,omitempty"`
+	Variants     Variants `json:"variants,omitempty"`
+}
+
+type UpdateURLRequest struct {
+	URL       *string    `json:"url,omitempty"`
+	Title     *string    `json:"title,omitempty"`
+	ExpiresAt NullableTime `json:"expires_at"`
+	MaxClicks *int64     `json:"max_clicks,omitempty"`
+	Password  *string    `json:"password,omitempty"`
+	RedirectStatus *int  `json:"redirect_status,omitempty"`
+	ForwardQuery *string `json:"forward_query,omitempty"`
+	ForwardPath  *bool   `json:"forward_path,omitempty"`
+	Rules        *Rules  `json:"rules,omitempty"`
+	Variants     *Variants `json:"variants,omitempty"`
+}
+
+// NullableTime is a time in an update request that tells a missing field
+// (Set is false) apart from an explicit null (Set is true, Time is nil)
+type NullableTime struct {
+	Set  bool
+	Time *time.Time
+}
+
+func (t *NullableTime) UnmarshalJSON(data []byte) error {
+	t.Set = true
+	t.Time = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &t.Time)
 }
 
 type URLResponse struct {
@@ -40,20 +142,37 @@ type URLResponse struct {
 	ShortCode   string    `json:"short_code"`
 	ShortURL    string    `json:"short_url"`
 	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
 	ClickCount  int64     `json:"click_count"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	MaxClicks   int64      `json:"max_clicks,omitempty"`
+	PasswordProtected bool `json:"password_protected"`
+	RedirectStatus int    `json:"redirect_status"`
+	ForwardQuery string   `json:"forward_query,omitempty"`
+	ForwardPath  bool     `json:"forward_path"`
+	Rules        Rules    `json:"rules,omitempty"`
+	Variants     Variants `json:"variants,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
 type ClickStatsResponse struct {
 	TotalClicks    int64                    `json:"total_clicks"`
+	UniqueClicks   int64                    `json:"unique_clicks"`
 	DailyClicks    []DailyClickStat         `json:"daily_clicks"`
 	CountryClicks  []CountryClickStat       `json:"country_clicks"`
 	RefererClicks  []RefererClickStat       `json:"referer_clicks"`
+	DeviceClicks   []DeviceClickStat        `json:"device_clicks"`
+	OSClicks       []OSClickStat            `json:"os_clicks"`
+	BrowserClicks  []BrowserClickStat       `json:"browser_clicks"`
+	VariantClicks  []VariantClickStat       `json:"variant_clicks,omitempty"`
 }
 
 type DailyClickStat struct {
-	Date  string `json:"date"`
-	Count int64  `json:"count"`
+	Date    string `json:"date"`
+	Count   int64  `json:"count"`
+	Uniques int64  `json:"uniques"`
 }
 
 type CountryClickStat struct {
@@ -64,4 +183,35 @@ type CountryClickStat struct {
 type RefererClickStat struct {
 	Referer string `json:"referer"`
 	Count   int64  `json:"count"`
-} 
\ No newline at end of file
+} 
+
+type DeviceClickStat struct {
+	Device string `json:"device"`
+	Count  int64  `json:"count"`
+}
+
+type OSClickStat struct {
+	OS    string `json:"os"`
+	Count int64  `json:"count"`
+}
+
+type BrowserClickStat struct {
+	Browser string `json:"browser"`
+	Count   int64  `json:"count"`
+}
+
+// VariantClickStat is how a split test variant performed. Variants no
+// longer on the link are listed with a zero weight.
+type VariantClickStat struct {
+	Variant        string  `json:"variant"`
+	Weight         int     `json:"weight"`
+	Count          int64   `json:"count"`
+	Visitors       int64   `json:"visitors"`
+	Conversions    int64   `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+type DomainStat struct {
+	Domain string `json:"domain"`
+	Count  int64  `json:"count"`
+}
\ No newline at end of file
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// Variant is one destination of a split test
+type Variant struct {
+	// Name identifies the variant on clicks, conversions and its visitors'
+	// cookies
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Weight is the variant's share of new visitors relative to the others;
+	// zero stops sending new visitors to it
+	Weight int `json:"weight"`
+}
+
+// Variants split a link's traffic across several destinations, each new
+// visitor being assigned one at random by weight and kept on it by a
+// cookie. They are stored like Rules.
+type Variants []Variant
+
+// Value implements driver.Valuer
+func (v Variants) Value() (driver.Value, error) {
+	return jsonColumnValue(v, len(v) == 0)
+}
+
+// Scan implements sql.Scanner
+func (v *Variants) Scan(value interface{}) error {
+	*v = nil
+	return scanJSONColumn(value, v)
+}
+
+// Find returns the variant called name
+func (v Variants) Find(name string) (Variant, bool) {
+	for _, variant := range v {
+		if variant.Name == name {
+			return variant, true
+		}
+	}
+	return Variant{}, false
+}
+
+// Conversion is a goal reached by a visitor of a split test variant, as
+// reported by the destination page. A visitor converts once per variant.
+type Conversion struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	URLId     uint      `json:"url_id" gorm:"not null;index;uniqueIndex:idx_conversions_visitor"`
+	Variant   string    `json:"variant" gorm:"size:64;not null;uniqueIndex:idx_conversions_visitor"`
+	VisitorID string    `json:"-" gorm:"size:32;not null;default:'';uniqueIndex:idx_conversions_visitor"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,215 @@
+// Package rollups maintains the hourly and daily click aggregates that the
+// statistics endpoints read instead of scanning raw clicks.
+package rollups
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"shorter-backend/models"
+	"shorter-backend/store"
+	"shorter-backend/uniques"
+)
+
+// Dimensions are the click attributes rolled up next to the totals
+var Dimensions = []store.Dimension{
+	store.DimensionCountry,
+	store.DimensionReferer,
+	store.DimensionDevice,
+	store.DimensionOS,
+	store.DimensionBrowser,
+	store.DimensionVariant,
+}
+
+// Granularities are the bucket sizes maintained, finest first
+var Granularities = []string{models.GranularityHour, models.GranularityDay}
+
+// Truncate returns the start of the UTC bucket containing t
+func Truncate(t time.Time, granularity string) time.Time {
+	t = t.UTC()
+	if granularity == models.GranularityDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// dimensionValue reads the value of a dimension from a click
+func dimensionValue(click *models.Click, dimension store.Dimension) string {
+	switch dimension {
+	case store.DimensionCountry:
+		return click.Country
+	case store.DimensionReferer:
+		return click.Referer
+	case store.DimensionDevice:
+		return click.Device
+	case store.DimensionOS:
+		return click.OS
+	case store.DimensionBrowser:
+		return click.Browser
+	case store.DimensionVariant:
+		return click.Variant
+	}
+	return ""
+}
+
+type rowKey struct {
+	urlID       uint
+	granularity string
+	bucket      time.Time
+	dimension   store.Dimension
+	value       string
+}
+
+// Aggregate folds clicks into rollup rows, one per link, bucket and
+// dimension value. Unique counts are the distinct human visitors among the
+// given clicks, so they are exact only when clicks covers whole buckets.
+// Rows are sorted by key so concurrent writers lock them in the same order.
+func Aggregate(clicks []models.Click) []models.ClickRollup {
+	rows := make(map[rowKey]*models.ClickRollup)
+	visitors := make(map[rowKey]map[string]struct{})
+
+	add := func(key rowKey, click *models.Click) *models.ClickRollup {
+		row, ok := rows[key]
+		if !ok {
+			row = &models.ClickRollup{
+				URLId:       key.urlID,
+				Granularity: key.granularity,
+				Bucket:      key.bucket,
+				Dimension:   string(key.dimension),
+				Value:       key.value,
+			}
+			rows[key] = row
+		}
+		if click.IsBot {
+			row.BotClicks++
+		} else {
+			row.Clicks++
+		}
+		return row
+	}
+
+	for i := range clicks {
+		click := &clicks[i]
+		for _, granularity := range Granularities {
+			total := rowKey{click.URLId, granularity, Truncate(click.CreatedAt, granularity), "", ""}
+			add(total, click)
+
+			if !click.IsBot {
+				// Clicks from before visitor fingerprints fall back to the
+				// raw client details, which are only compared in memory
+				visitor := click.VisitorID
+				if visitor == "" {
+					visitor = click.IPAddress + "\x00" + click.UserAgent
+				}
+				if visitors[total] == nil {
+					visitors[total] = make(map[string]struct{})
+				}
+				visitors[total][visitor] = struct{}{}
+			}
+
+			for _, dimension := range Dimensions {
+				if value := dimensionValue(click, dimension); value != "" {
+					add(rowKey{total.urlID, granularity, total.bucket, dimension, value}, click)
+				}
+			}
+		}
+	}
+
+	for key, seen := range visitors {
+		rows[key].Uniques = int64(len(seen))
+	}
+
+	result := make([]models.ClickRollup, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := &result[i], &result[j]
+		switch {
+		case a.URLId != b.URLId:
+			return a.URLId < b.URLId
+		case a.Granularity != b.Granularity:
+			return a.Granularity < b.Granularity
+		case !a.Bucket.Equal(b.Bucket):
+			return a.Bucket.Before(b.Bucket)
+		case a.Dimension != b.Dimension:
+			return a.Dimension < b.Dimension
+		}
+		return a.Value < b.Value
+	})
+	return result
+}
+
+// Record is an analytics sink adding a written batch to the rollups. It
+// must run after uniques.Record so bucket unique counts include the batch.
+func Record(ctx context.Context, clicks []models.Click) {
+	rows := Aggregate(clicks)
+
+	// A batch only sees part of a bucket's visitors; take the unique counts
+	// from the sketches that have seen all of them
+	for i := range rows {
+		row := &rows[i]
+		if row.Dimension != "" {
+			continue
+		}
+		count, err := uniques.Default.CountBucket(ctx, row.URLId, row.Granularity, row.Bucket)
+		if err != nil {
+			log.Printf("Failed to count unique visitors for URL %d: %v", row.URLId, err)
+			continue
+		}
+		row.Uniques = count
+	}
+
+	if err := store.Rollups.Add(ctx, rows); err != nil {
+		log.Printf("Failed to update rollups for %d clicks: %v", len(clicks), err)
+	}
+}
+
+// Backfill rebuilds the rollups of [from, to) from raw clicks, one UTC day
+// at a time. Days are replaced whole, so from and to are widened to day
+// boundaries; a zero from starts at the oldest click. Rebuilding a day that
+// is still receiving clicks can miss the ones written meanwhile.
+func Backfill(ctx context.Context, from, to time.Time, progress func(day time.Time, clicks int)) error {
+	if from.IsZero() {
+		oldest, err := store.Clicks.Oldest(ctx)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		from = oldest.CreatedAt
+	}
+
+	start := Truncate(from, models.GranularityDay)
+	end := Truncate(to, models.GranularityDay)
+	if end.Before(to) {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		next := day.AddDate(0, 0, 1)
+		clicks, err := store.Clicks.Between(ctx, day, next)
+		if err != nil {
+			return err
+		}
+		if err := store.Rollups.Clear(ctx, day, next); err != nil {
+			return err
+		}
+		if err := store.Rollups.Add(ctx, Aggregate(clicks)); err != nil {
+			return err
+		}
+
+		if progress != nil {
+			progress(day, len(clicks))
+		}
+	}
+	return nil
+}
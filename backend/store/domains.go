@@ -0,0 +1,38 @@
+package store
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"shorter-backend/models"
+)
+
+// countDomains tallies the hosts of the given URLs, ignoring a leading
+// "www.", and returns the most common ones
+func countDomains(rawURLs []string, limit int) []models.DomainStat {
+	counts := make(map[string]int64)
+	for _, rawURL := range rawURLs {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		counts[strings.TrimPrefix(parsed.Host, "www.")]++
+	}
+
+	stats := make([]models.DomainStat, 0, len(counts))
+	for domain, count := range counts {
+		stats = append(stats, models.DomainStat{Domain: domain, Count: count})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Domain < stats[j].Domain
+	})
+
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
@@ -0,0 +1,413 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"shorter-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormBackend stores everything through GORM. The SQL it issues is portable
+// between Postgres and SQLite except where a dialect hook says otherwise.
+type gormBackend struct {
+	db      *gorm.DB
+	dialect dialect
+}
+
+// dialect holds the queries that differ between SQL databases
+type dialect interface {
+	name() string
+	topDomains(db *gorm.DB, since time.Time, limit int) ([]models.DomainStat, error)
+}
+
+// NewGormBackend wraps an open GORM connection, picking the dialect from the
+// driver it was opened with
+func NewGormBackend(db *gorm.DB) Backend {
+	var d dialect = postgresDialect{}
+	if db.Dialector.Name() == "sqlite" {
+		d = sqliteDialect{}
+	}
+	return &gormBackend{db: db, dialect: d}
+}
+
+func (b *gormBackend) Name() string                 { return b.dialect.name() }
+func (b *gormBackend) Links() LinkStore             { return gormLinks{b} }
+func (b *gormBackend) Clicks() ClickStore           { return gormClicks{b} }
+func (b *gormBackend) Rollups() RollupStore         { return gormRollups{b} }
+func (b *gormBackend) Conversions() ConversionStore { return gormConversions{b} }
+func (b *gormBackend) Users() UserStore             { return gormUsers{b} }
+
+func (b *gormBackend) Ping(ctx context.Context) error {
+	return b.db.WithContext(ctx).Exec("SELECT 1").Error
+}
+
+// notFound maps GORM's missing-record error onto ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// duplicate reports unique constraint violations as ErrDuplicate
+func (b *gormBackend) duplicate(err error) error {
+	if translator, ok := b.db.Dialector.(gorm.ErrorTranslator); ok && err != nil {
+		if errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey) {
+			return ErrDuplicate
+		}
+	}
+	return err
+}
+
+// scoped applies an owner scope to a link query
+func scoped(db *gorm.DB, scope Scope) *gorm.DB {
+	if scope.All {
+		return db
+	}
+	if scope.UserID == nil {
+		return db.Where("user_id IS NULL")
+	}
+	return db.Where("user_id = ?", *scope.UserID)
+}
+
+// filtered applies a click filter to a click query
+func filtered(db *gorm.DB, filter ClickFilter) *gorm.DB {
+	if filter.IncludeBots {
+		return db
+	}
+	return db.Where("is_bot = ?", false)
+}
+
+type gormLinks struct{ *gormBackend }
+
+func (s gormLinks) Create(ctx context.Context, url *models.URL) error {
+	return s.duplicate(s.db.WithContext(ctx).Create(url).Error)
+}
+
+func (s gormLinks) Update(ctx context.Context, url *models.URL) error {
+	return s.db.WithContext(ctx).Model(url).Select("*").Omit(append([]string{"used_clicks", "created_at"}, metadataColumns...)...).Updates(url).Error
+}
+
+// metadataColumns are written by UpdateMetadata only
+var metadataColumns = []string{"page_title", "description", "image_url", "site_name", "metadata_fetched_at"}
+
+func (s gormLinks) UpdateMetadata(ctx context.Context, id uint, meta *models.PageMetadata, fetchedAt time.Time) error {
+	updates := map[string]interface{}{"metadata_fetched_at": fetchedAt}
+	if meta != nil {
+		// Every right-hand side sees the row as it was before the update
+		updates["title"] = gorm.Expr("CASE WHEN title = '' OR title = page_title THEN ? ELSE title END", meta.Title)
+		updates["page_title"] = meta.Title
+		updates["description"] = meta.Description
+		updates["image_url"] = meta.Image
+		updates["site_name"] = meta.SiteName
+	}
+	return s.db.WithContext(ctx).Model(&models.URL{}).Where("id = ?", id).UpdateColumns(updates).Error
+}
+
+func (s gormLinks) StaleMetadata(ctx context.Context, before time.Time, limit int) ([]models.URL, error) {
+	var urls []models.URL
+	err := s.db.WithContext(ctx).
+		Where("metadata_fetched_at IS NULL OR metadata_fetched_at < ?", before).
+		Order("id").Limit(limit).Find(&urls).Error
+	return urls, err
+}
+
+func (s gormLinks) Delete(ctx context.Context, url *models.URL) error {
+	return s.db.WithContext(ctx).Delete(url).Error
+}
+
+func (s gormLinks) FindByCode(ctx context.Context, scope Scope, code string) (*models.URL, error) {
+	var url models.URL
+	err := scoped(s.db.WithContext(ctx), scope).Where("short_code = ?", code).First(&url).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &url, nil
+}
+
+func (s gormLinks) FindReusable(ctx context.Context, scope Scope, originalURL string) (*models.URL, error) {
+	var url models.URL
+	err := scoped(s.db.WithContext(ctx), scope).
+		Where("original_url = ? AND expires_at IS NULL AND max_clicks = 0 AND password_hash = '' AND redirect_status = ? AND forward_query = '' AND forward_path = ? AND rules = '' AND variants = ''", originalURL, models.DefaultRedirectStatus, false).
+		First(&url).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &url, nil
+}
+
+func (s gormLinks) CodeExists(ctx context.Context, code string) (bool, error) {
+	var count int64
+	// Deleted links keep their code reserved by the unique index
+	err := s.db.WithContext(ctx).Unscoped().Model(&models.URL{}).Where("short_code = ?", code).Count(&count).Error
+	return count > 0, err
+}
+
+func (s gormLinks) List(ctx context.Context, scope Scope, offset, limit int) ([]models.URL, int64, error) {
+	var total int64
+	if err := scoped(s.db.WithContext(ctx).Model(&models.URL{}), scope).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var urls []models.URL
+	err := scoped(s.db.WithContext(ctx), scope).Limit(limit).Offset(offset).Order("created_at desc").Find(&urls).Error
+	return urls, total, err
+}
+
+func (s gormLinks) ConsumeClick(ctx context.Context, id uint) (bool, error) {
+	result := s.db.WithContext(ctx).Model(&models.URL{}).
+		Where("id = ? AND used_clicks < max_clicks", id).
+		UpdateColumn("used_clicks", gorm.Expr("used_clicks + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+func (s gormLinks) CountSince(ctx context.Context, since time.Time) (int64, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.URL{}).Where("created_at >= ?", since).Count(&count).Error
+	return count, err
+}
+
+func (s gormLinks) TopDomains(ctx context.Context, since time.Time, limit int) ([]models.DomainStat, error) {
+	return s.dialect.topDomains(s.db.WithContext(ctx), since, limit)
+}
+
+func (s gormLinks) Recent(ctx context.Context, limit int) ([]models.URL, error) {
+	var urls []models.URL
+	err := s.db.WithContext(ctx).Order("created_at desc").Limit(limit).Find(&urls).Error
+	return urls, err
+}
+
+type gormClicks struct{ *gormBackend }
+
+func (s gormClicks) Create(ctx context.Context, click *models.Click) error {
+	return s.db.WithContext(ctx).Create(click).Error
+}
+
+func (s gormClicks) CreateBatch(ctx context.Context, clicks []models.Click) error {
+	return s.db.WithContext(ctx).CreateInBatches(clicks, len(clicks)).Error
+}
+
+func (s gormClicks) Between(ctx context.Context, from, to time.Time) ([]models.Click, error) {
+	var clicks []models.Click
+	err := s.db.WithContext(ctx).
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Order("created_at, id").
+		Find(&clicks).Error
+	return clicks, err
+}
+
+func (s gormClicks) Oldest(ctx context.Context) (*models.Click, error) {
+	var click models.Click
+	if err := s.db.WithContext(ctx).Order("created_at").First(&click).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &click, nil
+}
+
+func (s gormClicks) Recent(ctx context.Context, limit int, filter ClickFilter) ([]models.Click, error) {
+	var clicks []models.Click
+	err := filtered(s.db.WithContext(ctx), filter).Order("created_at desc").Limit(limit).Find(&clicks).Error
+	return clicks, err
+}
+
+func (s gormClicks) VisitorsByVariant(ctx context.Context, urlID uint) ([]ValueCount, error) {
+	var stats []ValueCount
+	err := s.db.WithContext(ctx).Model(&models.Click{}).
+		Select("variant as value, COUNT(DISTINCT visitor_id) as count").
+		Where("url_id = ? AND variant <> '' AND visitor_id <> '' AND is_bot = ?", urlID, false).
+		Group("variant").
+		Order("count DESC").
+		Scan(&stats).Error
+	return stats, err
+}
+
+type gormRollups struct{ *gormBackend }
+
+// rollupColumns are the primary key columns of click_rollups
+var rollupColumns = []clause.Column{{Name: "url_id"}, {Name: "granularity"}, {Name: "bucket"}, {Name: "dimension"}, {Name: "value"}}
+
+func (s gormRollups) Add(ctx context.Context, rows []models.ClickRollup) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: rollupColumns,
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"clicks":     gorm.Expr("click_rollups.clicks + excluded.clicks"),
+			"bot_clicks": gorm.Expr("click_rollups.bot_clicks + excluded.bot_clicks"),
+			"uniques":    gorm.Expr("CASE WHEN excluded.uniques > click_rollups.uniques THEN excluded.uniques ELSE click_rollups.uniques END"),
+		}),
+	}).CreateInBatches(rows, 500).Error
+}
+
+func (s gormRollups) Clear(ctx context.Context, from, to time.Time) error {
+	return s.db.WithContext(ctx).
+		Where("bucket >= ? AND bucket < ?", from.UTC(), to.UTC()).
+		Delete(&models.ClickRollup{}).Error
+}
+
+// rollupClicks is the column expression counting the clicks a query asks for
+func rollupClicks(filter ClickFilter) string {
+	if filter.IncludeBots {
+		return "clicks + bot_clicks"
+	}
+	return "clicks"
+}
+
+// rollupRows selects the rows of one dimension ("" for totals) matching q
+func (s gormRollups) rollupRows(ctx context.Context, q RollupQuery, dimension Dimension) *gorm.DB {
+	db := s.db.WithContext(ctx).Model(&models.ClickRollup{}).
+		Where("granularity = ? AND dimension = ?", q.Granularity, string(dimension))
+	if q.URLID != 0 {
+		db = db.Where("url_id = ?", q.URLID)
+	}
+	if !q.From.IsZero() {
+		db = db.Where("bucket >= ?", q.From.UTC())
+	}
+	if !q.To.IsZero() {
+		db = db.Where("bucket < ?", q.To.UTC())
+	}
+	return db
+}
+
+func (s gormRollups) Sum(ctx context.Context, q RollupQuery) (int64, error) {
+	var total int64
+	err := s.rollupRows(ctx, q, "").
+		Select("COALESCE(SUM(" + rollupClicks(q.Filter) + "), 0)").
+		Scan(&total).Error
+	return total, err
+}
+
+func (s gormRollups) Series(ctx context.Context, q RollupQuery) ([]BucketCount, error) {
+	clicks := rollupClicks(q.Filter)
+	var rows []models.ClickRollup
+	err := s.rollupRows(ctx, q, "").
+		Select("bucket, SUM(" + clicks + ") as clicks, SUM(uniques) as uniques").
+		Group("bucket").
+		Having("SUM(" + clicks + ") > 0").
+		Order("bucket").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]BucketCount, 0, len(rows))
+	for _, row := range rows {
+		series = append(series, BucketCount{Bucket: row.Bucket.UTC(), Clicks: row.Clicks, Uniques: row.Uniques})
+	}
+	return series, nil
+}
+
+func (s gormRollups) TopValues(ctx context.Context, q RollupQuery, dimension Dimension, limit int) ([]ValueCount, error) {
+	if dimension == "" {
+		return nil, errors.New("a dimension is required")
+	}
+
+	clicks := rollupClicks(q.Filter)
+	var stats []ValueCount
+	err := s.rollupRows(ctx, q, dimension).
+		Select("value, SUM(" + clicks + ") as count").
+		Group("value").
+		Having("SUM(" + clicks + ") > 0").
+		Order("count DESC").
+		Limit(limit).
+		Scan(&stats).Error
+	return stats, err
+}
+
+type gormConversions struct{ *gormBackend }
+
+func (s gormConversions) Create(ctx context.Context, conversion *models.Conversion) error {
+	return s.duplicate(s.db.WithContext(ctx).Create(conversion).Error)
+}
+
+func (s gormConversions) CountByVariant(ctx context.Context, urlID uint) ([]ValueCount, error) {
+	var stats []ValueCount
+	err := s.db.WithContext(ctx).Model(&models.Conversion{}).
+		Select("variant as value, COUNT(*) as count").
+		Where("url_id = ?", urlID).
+		Group("variant").
+		Order("count DESC").
+		Scan(&stats).Error
+	return stats, err
+}
+
+type gormUsers struct{ *gormBackend }
+
+func (s gormUsers) Create(ctx context.Context, user *models.User) error {
+	return s.db.WithContext(ctx).Create(user).Error
+}
+
+func (s gormUsers) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := s.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (s gormUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := s.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (s gormUsers) List(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	err := s.db.WithContext(ctx).Order("created_at desc").Find(&users).Error
+	return users, err
+}
+
+func (s gormUsers) UpdateRole(ctx context.Context, user *models.User, role string) error {
+	return s.db.WithContext(ctx).Model(user).Update("role", role).Error
+}
+
+func (s gormUsers) UpdatePlan(ctx context.Context, user *models.User, plan string) error {
+	return s.db.WithContext(ctx).Model(user).Update("plan", plan).Error
+}
+
+func (s gormUsers) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	return s.db.WithContext(ctx).Create(key).Error
+}
+
+func (s gormUsers) FindAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := s.db.WithContext(ctx).Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &key, nil
+}
+
+func (s gormUsers) FindAPIKey(ctx context.Context, userID, id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&key).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &key, nil
+}
+
+func (s gormUsers) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at desc").Find(&keys).Error
+	return keys, err
+}
+
+func (s gormUsers) CountActiveAPIKeys(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+func (s gormUsers) RevokeAPIKey(ctx context.Context, key *models.APIKey, at time.Time) error {
+	return s.db.WithContext(ctx).Model(key).Update("revoked_at", at).Error
+}
+
+func (s gormUsers) TouchAPIKey(ctx context.Context, key *models.APIKey, at time.Time) error {
+	return s.db.WithContext(ctx).Model(key).UpdateColumn("last_used_at", at).Error
+}
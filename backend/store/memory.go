@@ -0,0 +1,751 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"shorter-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// memoryBackend keeps everything in process memory. It needs no database
+// server, which makes it handy on a laptop and in tests, but loses all data
+// on restart.
+type memoryBackend struct {
+	mu sync.RWMutex
+
+	urls    map[uint]*models.URL
+	clicks  []models.Click
+	rollups map[rollupKey]*models.ClickRollup
+	users   map[uint]*models.User
+	keys    map[uint]*models.APIKey
+
+	conversions []models.Conversion
+
+	nextURLID        uint
+	nextClickID      uint
+	nextConversionID uint
+	nextUserID       uint
+	nextKeyID        uint
+}
+
+// NewMemoryBackend returns an empty in-memory backend
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		urls:    make(map[uint]*models.URL),
+		rollups: make(map[rollupKey]*models.ClickRollup),
+		users:   make(map[uint]*models.User),
+		keys:    make(map[uint]*models.APIKey),
+	}
+}
+
+func (b *memoryBackend) Name() string                 { return "memory" }
+func (b *memoryBackend) Links() LinkStore             { return memoryLinks{b} }
+func (b *memoryBackend) Clicks() ClickStore           { return memoryClicks{b} }
+func (b *memoryBackend) Rollups() RollupStore         { return memoryRollups{b} }
+func (b *memoryBackend) Conversions() ConversionStore { return memoryConversions{b} }
+func (b *memoryBackend) Users() UserStore             { return memoryUsers{b} }
+
+func (b *memoryBackend) Ping(ctx context.Context) error { return nil }
+
+// inScope reports whether a link is visible under the given owner scope
+func inScope(url *models.URL, scope Scope) bool {
+	if scope.All {
+		return true
+	}
+	if scope.UserID == nil {
+		return url.UserID == nil
+	}
+	return url.UserID != nil && *url.UserID == *scope.UserID
+}
+
+// liveURLs returns the links that have not been deleted, newest first.
+// Callers must hold the lock.
+func (b *memoryBackend) liveURLs() []*models.URL {
+	urls := make([]*models.URL, 0, len(b.urls))
+	for _, url := range b.urls {
+		if !url.DeletedAt.Valid {
+			urls = append(urls, url)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].ID > urls[j].ID })
+	return urls
+}
+
+type memoryLinks struct{ *memoryBackend }
+
+func (s memoryLinks) Create(ctx context.Context, url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Deleted links keep their code reserved, as the unique index does in SQL
+	for _, existing := range s.urls {
+		if existing.ShortCode == url.ShortCode {
+			return ErrDuplicate
+		}
+	}
+
+	now := time.Now()
+	s.nextURLID++
+	url.ID = s.nextURLID
+	url.CreatedAt = now
+	url.UpdatedAt = now
+
+	stored := *url
+	s.urls[url.ID] = &stored
+	return nil
+}
+
+func (s memoryLinks) Update(ctx context.Context, url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.urls[url.ID]
+	if !ok || existing.DeletedAt.Valid {
+		return ErrNotFound
+	}
+
+	url.UpdatedAt = time.Now()
+	stored := *url
+	stored.UsedClicks = existing.UsedClicks
+	stored.CreatedAt = existing.CreatedAt
+	stored.PageTitle = existing.PageTitle
+	stored.Description = existing.Description
+	stored.ImageURL = existing.ImageURL
+	stored.SiteName = existing.SiteName
+	stored.MetadataFetchedAt = existing.MetadataFetchedAt
+	s.urls[url.ID] = &stored
+	return nil
+}
+
+func (s memoryLinks) UpdateMetadata(ctx context.Context, id uint, meta *models.PageMetadata, fetchedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[id]
+	if !ok {
+		return ErrNotFound
+	}
+	url.MetadataFetchedAt = &fetchedAt
+	if meta != nil {
+		if url.Title == "" || url.Title == url.PageTitle {
+			url.Title = meta.Title
+		}
+		url.PageTitle = meta.Title
+		url.Description = meta.Description
+		url.ImageURL = meta.Image
+		url.SiteName = meta.SiteName
+	}
+	return nil
+}
+
+func (s memoryLinks) StaleMetadata(ctx context.Context, before time.Time, limit int) ([]models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var urls []models.URL
+	for _, url := range s.liveURLs() {
+		if url.MetadataFetchedAt == nil || url.MetadataFetchedAt.Before(before) {
+			urls = append(urls, *url)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].ID < urls[j].ID })
+	if len(urls) > limit {
+		urls = urls[:limit]
+	}
+	return urls, nil
+}
+
+func (s memoryLinks) Delete(ctx context.Context, url *models.URL) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.urls[url.ID]
+	if !ok || existing.DeletedAt.Valid {
+		return ErrNotFound
+	}
+	existing.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (s memoryLinks) FindByCode(ctx context.Context, scope Scope, code string) (*models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, url := range s.liveURLs() {
+		if url.ShortCode == code && inScope(url, scope) {
+			found := *url
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s memoryLinks) FindReusable(ctx context.Context, scope Scope, originalURL string) (*models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Oldest first, like First in SQL
+	urls := s.liveURLs()
+	for i := len(urls) - 1; i >= 0; i-- {
+		url := urls[i]
+		if url.OriginalURL == originalURL && url.ExpiresAt == nil && url.MaxClicks == 0 && url.PasswordHash == "" &&
+			url.RedirectCode() == models.DefaultRedirectStatus && url.ForwardQuery == "" && !url.ForwardPath && len(url.Rules) == 0 && len(url.Variants) == 0 &&
+			inScope(url, scope) {
+			found := *url
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s memoryLinks) CodeExists(ctx context.Context, code string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Deleted links keep their code reserved
+	for _, url := range s.urls {
+		if url.ShortCode == code {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s memoryLinks) List(ctx context.Context, scope Scope, offset, limit int) ([]models.URL, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []models.URL
+	for _, url := range s.liveURLs() {
+		if inScope(url, scope) {
+			matched = append(matched, *url)
+		}
+	}
+
+	total := int64(len(matched))
+	if offset >= len(matched) {
+		return []models.URL{}, total, nil
+	}
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[offset:end], total, nil
+}
+
+func (s memoryLinks) ConsumeClick(ctx context.Context, id uint) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	url, ok := s.urls[id]
+	if !ok || url.UsedClicks >= url.MaxClicks {
+		return false, nil
+	}
+	url.UsedClicks++
+	return true, nil
+}
+
+func (s memoryLinks) CountSince(ctx context.Context, since time.Time) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, url := range s.liveURLs() {
+		if !url.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s memoryLinks) TopDomains(ctx context.Context, since time.Time, limit int) ([]models.DomainStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var originalURLs []string
+	for _, url := range s.liveURLs() {
+		if !url.CreatedAt.Before(since) {
+			originalURLs = append(originalURLs, url.OriginalURL)
+		}
+	}
+	return countDomains(originalURLs, limit), nil
+}
+
+func (s memoryLinks) Recent(ctx context.Context, limit int) ([]models.URL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	live := s.liveURLs()
+	if len(live) > limit {
+		live = live[:limit]
+	}
+	urls := make([]models.URL, 0, len(live))
+	for _, url := range live {
+		urls = append(urls, *url)
+	}
+	return urls, nil
+}
+
+type memoryClicks struct{ *memoryBackend }
+
+func (s memoryClicks) Create(ctx context.Context, click *models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextClickID++
+	click.ID = s.nextClickID
+	if click.CreatedAt.IsZero() {
+		click.CreatedAt = time.Now()
+	}
+	s.clicks = append(s.clicks, *click)
+	return nil
+}
+
+func (s memoryClicks) CreateBatch(ctx context.Context, clicks []models.Click) error {
+	for i := range clicks {
+		if err := s.Create(ctx, &clicks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s memoryClicks) Between(ctx context.Context, from, to time.Time) ([]models.Click, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var clicks []models.Click
+	for _, click := range s.clicks {
+		if !click.CreatedAt.Before(from) && click.CreatedAt.Before(to) {
+			clicks = append(clicks, click)
+		}
+	}
+	sort.SliceStable(clicks, func(i, j int) bool { return clicks[i].CreatedAt.Before(clicks[j].CreatedAt) })
+	return clicks, nil
+}
+
+func (s memoryClicks) Oldest(ctx context.Context) (*models.Click, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var oldest *models.Click
+	for i := range s.clicks {
+		if oldest == nil || s.clicks[i].CreatedAt.Before(oldest.CreatedAt) {
+			oldest = &s.clicks[i]
+		}
+	}
+	if oldest == nil {
+		return nil, ErrNotFound
+	}
+	click := *oldest
+	return &click, nil
+}
+
+func (s memoryClicks) Recent(ctx context.Context, limit int, filter ClickFilter) ([]models.Click, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clicks := make([]models.Click, 0, limit)
+	for i := len(s.clicks) - 1; i >= 0 && len(clicks) < limit; i-- {
+		if filter.Matches(&s.clicks[i]) {
+			clicks = append(clicks, s.clicks[i])
+		}
+	}
+	return clicks, nil
+}
+
+func (s memoryClicks) VisitorsByVariant(ctx context.Context, urlID uint) ([]ValueCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	visitors := make(map[string]map[string]struct{})
+	for i := range s.clicks {
+		click := &s.clicks[i]
+		if click.URLId != urlID || click.Variant == "" || click.VisitorID == "" || click.IsBot {
+			continue
+		}
+		if visitors[click.Variant] == nil {
+			visitors[click.Variant] = make(map[string]struct{})
+		}
+		visitors[click.Variant][click.VisitorID] = struct{}{}
+	}
+
+	counts := make(map[string]int64, len(visitors))
+	for variant, seen := range visitors {
+		counts[variant] = int64(len(seen))
+	}
+	return topCounts(counts, len(counts)), nil
+}
+
+// topCounts sorts value counts from most to least frequent and truncates
+// them to limit
+func topCounts(counts map[string]int64, limit int) []ValueCount {
+	stats := make([]ValueCount, 0, len(counts))
+	for value, count := range counts {
+		stats = append(stats, ValueCount{Value: value, Count: count})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Count != stats[j].Count {
+			return stats[i].Count > stats[j].Count
+		}
+		return stats[i].Value < stats[j].Value
+	})
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
+
+// rollupKey identifies one rollup row
+type rollupKey struct {
+	urlID       uint
+	granularity string
+	bucket      int64
+	dimension   string
+	value       string
+}
+
+func keyOf(row *models.ClickRollup) rollupKey {
+	return rollupKey{row.URLId, row.Granularity, row.Bucket.Unix(), row.Dimension, row.Value}
+}
+
+type memoryRollups struct{ *memoryBackend }
+
+func (s memoryRollups) Add(ctx context.Context, rows []models.ClickRollup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range rows {
+		key := keyOf(&rows[i])
+		existing, ok := s.rollups[key]
+		if !ok {
+			row := rows[i]
+			row.Bucket = row.Bucket.UTC()
+			s.rollups[key] = &row
+			continue
+		}
+		existing.Clicks += rows[i].Clicks
+		existing.BotClicks += rows[i].BotClicks
+		if rows[i].Uniques > existing.Uniques {
+			existing.Uniques = rows[i].Uniques
+		}
+	}
+	return nil
+}
+
+func (s memoryRollups) Clear(ctx context.Context, from, to time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, row := range s.rollups {
+		if !row.Bucket.Before(from) && row.Bucket.Before(to) {
+			delete(s.rollups, key)
+		}
+	}
+	return nil
+}
+
+// eachRollup calls fn with the clicks of every row of one dimension ("" for
+// totals) matching q. Callers must hold the lock.
+func (s memoryRollups) eachRollup(q RollupQuery, dimension Dimension, fn func(row *models.ClickRollup, clicks int64)) {
+	for _, row := range s.rollups {
+		if row.Granularity != q.Granularity || row.Dimension != string(dimension) {
+			continue
+		}
+		if q.URLID != 0 && row.URLId != q.URLID {
+			continue
+		}
+		if !q.From.IsZero() && row.Bucket.Before(q.From) {
+			continue
+		}
+		if !q.To.IsZero() && !row.Bucket.Before(q.To) {
+			continue
+		}
+
+		clicks := row.Clicks
+		if q.Filter.IncludeBots {
+			clicks += row.BotClicks
+		}
+		fn(row, clicks)
+	}
+}
+
+func (s memoryRollups) Sum(ctx context.Context, q RollupQuery) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total int64
+	s.eachRollup(q, "", func(row *models.ClickRollup, clicks int64) {
+		total += clicks
+	})
+	return total, nil
+}
+
+func (s memoryRollups) Series(ctx context.Context, q RollupQuery) ([]BucketCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buckets := make(map[int64]*BucketCount)
+	s.eachRollup(q, "", func(row *models.ClickRollup, clicks int64) {
+		b, ok := buckets[row.Bucket.Unix()]
+		if !ok {
+			b = &BucketCount{Bucket: row.Bucket}
+			buckets[row.Bucket.Unix()] = b
+		}
+		b.Clicks += clicks
+		b.Uniques += row.Uniques
+	})
+
+	series := make([]BucketCount, 0, len(buckets))
+	for _, b := range buckets {
+		if b.Clicks > 0 {
+			series = append(series, *b)
+		}
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Bucket.Before(series[j].Bucket) })
+	return series, nil
+}
+
+func (s memoryRollups) TopValues(ctx context.Context, q RollupQuery, dimension Dimension, limit int) ([]ValueCount, error) {
+	if dimension == "" {
+		return nil, errors.New("a dimension is required")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	s.eachRollup(q, dimension, func(row *models.ClickRollup, clicks int64) {
+		if clicks > 0 {
+			counts[row.Value] += clicks
+		}
+	})
+	return topCounts(counts, limit), nil
+}
+
+type memoryConversions struct{ *memoryBackend }
+
+func (s memoryConversions) Create(ctx context.Context, conversion *models.Conversion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.conversions {
+		existing := &s.conversions[i]
+		if existing.URLId == conversion.URLId && existing.Variant == conversion.Variant && existing.VisitorID == conversion.VisitorID {
+			return ErrDuplicate
+		}
+	}
+
+	s.nextConversionID++
+	conversion.ID = s.nextConversionID
+	if conversion.CreatedAt.IsZero() {
+		conversion.CreatedAt = time.Now()
+	}
+	s.conversions = append(s.conversions, *conversion)
+	return nil
+}
+
+func (s memoryConversions) CountByVariant(ctx context.Context, urlID uint) ([]ValueCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for i := range s.conversions {
+		if s.conversions[i].URLId == urlID {
+			counts[s.conversions[i].Variant]++
+		}
+	}
+	return topCounts(counts, len(counts)), nil
+}
+
+type memoryUsers struct{ *memoryBackend }
+
+func (s memoryUsers) Create(ctx context.Context, user *models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return errors.New("email already exists")
+		}
+	}
+
+	now := time.Now()
+	s.nextUserID++
+	user.ID = s.nextUserID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	stored := *user
+	s.users[user.ID] = &stored
+	return nil
+}
+
+func (s memoryUsers) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s memoryUsers) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s memoryUsers) List(ctx context.Context) ([]models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]models.User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID > users[j].ID })
+	return users, nil
+}
+
+func (s memoryUsers) UpdateRole(ctx context.Context, user *models.User, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Role = role
+	stored.UpdatedAt = time.Now()
+	user.Role = role
+	user.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+func (s memoryUsers) UpdatePlan(ctx context.Context, user *models.User, plan string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Plan = plan
+	stored.UpdatedAt = time.Now()
+	user.Plan = plan
+	user.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+func (s memoryUsers) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.keys {
+		if existing.KeyHash == key.KeyHash {
+			return errors.New("api key already exists")
+		}
+	}
+
+	s.nextKeyID++
+	key.ID = s.nextKeyID
+	key.CreatedAt = time.Now()
+
+	stored := *key
+	s.keys[key.ID] = &stored
+	return nil
+}
+
+func (s memoryUsers) FindAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, key := range s.keys {
+		if key.KeyHash == hash {
+			found := *key
+			return &found, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s memoryUsers) FindAPIKey(ctx context.Context, userID, id uint) (*models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.UserID != userID {
+		return nil, ErrNotFound
+	}
+	found := *key
+	return &found, nil
+}
+
+func (s memoryUsers) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []models.APIKey
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			keys = append(keys, *key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (s memoryUsers) CountActiveAPIKeys(ctx context.Context, userID uint) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, key := range s.keys {
+		if key.UserID == userID && key.RevokedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s memoryUsers) RevokeAPIKey(ctx context.Context, key *models.APIKey, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.keys[key.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.RevokedAt = &at
+	key.RevokedAt = &at
+	return nil
+}
+
+func (s memoryUsers) TouchAPIKey(ctx context.Context, key *models.APIKey, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.keys[key.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.LastUsedAt = &at
+	key.LastUsedAt = &at
+	return nil
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"time"
+
+	"shorter-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// postgresDialect pushes domain extraction down into Postgres
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return "postgres" }
+
+func (postgresDialect) topDomains(db *gorm.DB, since time.Time, limit int) ([]models.DomainStat, error) {
+	var stats []models.DomainStat
+	err := db.Raw(`
+		SELECT 
+			REGEXP_REPLACE(original_url, '^https?://(?:www\.)?([^/]+).*', '\1') as domain,
+			COUNT(*) as count
+		FROM urls 
+		WHERE created_at >= ? AND deleted_at IS NULL
+		GROUP BY domain 
+		ORDER BY count DESC 
+		LIMIT ?
+	`, since, limit).Scan(&stats).Error
+	return stats, err
+}
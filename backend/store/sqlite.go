@@ -0,0 +1,23 @@
+package store
+
+import (
+	"time"
+
+	"shorter-backend/models"
+
+	"gorm.io/gorm"
+)
+
+// sqliteDialect covers what SQLite cannot express in SQL, such as regular
+// expressions, by finishing the work in Go
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return "sqlite" }
+
+func (sqliteDialect) topDomains(db *gorm.DB, since time.Time, limit int) ([]models.DomainStat, error) {
+	var originalURLs []string
+	if err := db.Model(&models.URL{}).Where("created_at >= ?", since).Pluck("original_url", &originalURLs).Error; err != nil {
+		return nil, err
+	}
+	return countDomains(originalURLs, limit), nil
+}
@@ -0,0 +1,207 @@
+// Package store hides the persistence of links, clicks, rollups, conversions and users behind
+// interfaces so the service can run on Postgres, SQLite or purely in memory.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"shorter-backend/models"
+)
+
+// ErrNotFound is returned when a lookup matches no record
+var ErrNotFound = errors.New("record not found")
+
+// ErrDuplicate is returned when a record would take a unique value, such as
+// a short code, that is already taken
+var ErrDuplicate = errors.New("record already exists")
+
+// Scope restricts link lookups to an owner. A nil UserID matches anonymous
+// links; All lifts the restriction entirely.
+type Scope struct {
+	UserID *uint
+	All    bool
+}
+
+// AnyOwner is the unrestricted scope
+var AnyOwner = Scope{All: true}
+
+// Dimension is a click attribute statistics can be grouped by
+type Dimension string
+
+const (
+	DimensionCountry Dimension = "country"
+	DimensionReferer Dimension = "referer"
+	DimensionDevice  Dimension = "device"
+	DimensionOS      Dimension = "os"
+	DimensionBrowser Dimension = "browser"
+	DimensionVariant Dimension = "variant"
+)
+
+// ValueCount is the number of clicks for one value of a dimension
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// ClickFilter narrows the clicks analytics queries count. Bot traffic is
+// left out unless IncludeBots is set.
+type ClickFilter struct {
+	IncludeBots bool
+}
+
+// Matches reports whether click passes the filter
+func (f ClickFilter) Matches(click *models.Click) bool {
+	return f.IncludeBots || !click.IsBot
+}
+
+// LinkStore persists short links
+type LinkStore interface {
+	// Create fails with ErrDuplicate when the short code is taken
+	Create(ctx context.Context, url *models.URL) error
+	// Update saves every field of url except the consumed click budget and
+	// the fetched page metadata
+	Update(ctx context.Context, url *models.URL) error
+	Delete(ctx context.Context, url *models.URL) error
+	FindByCode(ctx context.Context, scope Scope, code string) (*models.URL, error)
+	// FindReusable returns a link to originalURL without expiry, click
+	// budget, password, forwarding, rules, variants or a redirect status
+	// other than the default that can be handed out again instead of a new
+	// one, the oldest when there are several
+	FindReusable(ctx context.Context, scope Scope, originalURL string) (*models.URL, error)
+	// CodeExists reports whether code is taken, counting deleted links,
+	// which keep their code reserved
+	CodeExists(ctx context.Context, code string) (bool, error)
+	List(ctx context.Context, scope Scope, offset, limit int) ([]models.URL, int64, error)
+	// ConsumeClick takes one click from the link's budget, reporting false
+	// once max_clicks has been reached
+	ConsumeClick(ctx context.Context, id uint) (bool, error)
+	// UpdateMetadata records a metadata fetch of the link's destination. A
+	// nil meta (a failed fetch) keeps the metadata from earlier fetches. The
+	// title is only replaced while it is empty or came from the page.
+	UpdateMetadata(ctx context.Context, id uint, meta *models.PageMetadata, fetchedAt time.Time) error
+	// StaleMetadata returns links whose metadata was never fetched or last
+	// fetched before the given time
+	StaleMetadata(ctx context.Context, before time.Time, limit int) ([]models.URL, error)
+	// CountSince counts links created at or after since (zero for all)
+	CountSince(ctx context.Context, since time.Time) (int64, error)
+	TopDomains(ctx context.Context, since time.Time, limit int) ([]models.DomainStat, error)
+	Recent(ctx context.Context, limit int) ([]models.URL, error)
+}
+
+// ClickStore persists raw click events. Statistics are answered from the
+// rollups rather than by scanning clicks.
+type ClickStore interface {
+	Create(ctx context.Context, click *models.Click) error
+	CreateBatch(ctx context.Context, clicks []models.Click) error
+	// Between returns the clicks made in [from, to), oldest first
+	Between(ctx context.Context, from, to time.Time) ([]models.Click, error)
+	// Oldest returns the first click ever recorded
+	Oldest(ctx context.Context) (*models.Click, error)
+	Recent(ctx context.Context, limit int, filter ClickFilter) ([]models.Click, error)
+	// VisitorsByVariant counts the distinct human visitors of each split
+	// test variant of a link
+	VisitorsByVariant(ctx context.Context, urlID uint) ([]ValueCount, error)
+}
+
+// RollupQuery selects rollup rows of one granularity. A zero URLID covers
+// every link and a zero From or To leaves that end of the range open.
+type RollupQuery struct {
+	URLID       uint
+	Granularity string
+	From        time.Time
+	To          time.Time
+	Filter      ClickFilter
+}
+
+// BucketCount is the number of clicks and unique visitors in one bucket
+type BucketCount struct {
+	Bucket  time.Time
+	Clicks  int64
+	Uniques int64
+}
+
+// RollupStore persists pre-aggregated click counts
+type RollupStore interface {
+	// Add merges rows into the stored rollups: click counts are added up and
+	// unique counts keep the larger value. Rows must not repeat a key.
+	Add(ctx context.Context, rows []models.ClickRollup) error
+	// Clear deletes the rollups of every bucket in [from, to)
+	Clear(ctx context.Context, from, to time.Time) error
+	// Sum totals the clicks matching the query
+	Sum(ctx context.Context, q RollupQuery) (int64, error)
+	// Series returns per-bucket totals, oldest first. Buckets without clicks
+	// are left out; unique counts of several links are summed, not merged.
+	Series(ctx context.Context, q RollupQuery) ([]BucketCount, error)
+	TopValues(ctx context.Context, q RollupQuery, dimension Dimension, limit int) ([]ValueCount, error)
+}
+
+// ConversionStore persists the conversions reported for split test variants
+type ConversionStore interface {
+	// Create fails with ErrDuplicate when the visitor already converted on
+	// the variant
+	Create(ctx context.Context, conversion *models.Conversion) error
+	// CountByVariant totals a link's conversions per variant
+	CountByVariant(ctx context.Context, urlID uint) ([]ValueCount, error)
+}
+
+// UserStore persists user accounts and their API keys
+type UserStore interface {
+	Create(ctx context.Context, user *models.User) error
+	FindByID(ctx context.Context, id uint) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	List(ctx context.Context) ([]models.User, error)
+	UpdateRole(ctx context.Context, user *models.User, role string) error
+	UpdatePlan(ctx context.Context, user *models.User, plan string) error
+
+	CreateAPIKey(ctx context.Context, key *models.APIKey) error
+	// FindAPIKeyByHash returns the key with the given hash, revoked or not
+	FindAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	FindAPIKey(ctx context.Context, userID, id uint) (*models.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error)
+	CountActiveAPIKeys(ctx context.Context, userID uint) (int64, error)
+	RevokeAPIKey(ctx context.Context, key *models.APIKey, at time.Time) error
+	TouchAPIKey(ctx context.Context, key *models.APIKey, at time.Time) error
+}
+
+// Backend bundles the stores of one storage implementation
+type Backend interface {
+	Name() string
+	Links() LinkStore
+	Clicks() ClickStore
+	Rollups() RollupStore
+	Conversions() ConversionStore
+	Users() UserStore
+	Ping(ctx context.Context) error
+}
+
+var (
+	Links       LinkStore
+	Clicks      ClickStore
+	Rollups     RollupStore
+	Conversions ConversionStore
+	Users       UserStore
+
+	current Backend
+)
+
+// Use installs b as the backend behind the package-level stores
+func Use(b Backend) {
+	current = b
+	Links = b.Links()
+	Clicks = b.Clicks()
+	Rollups = b.Rollups()
+	Conversions = b.Conversions()
+	Users = b.Users()
+}
+
+// Name returns the name of the active backend
+func Name() string {
+	return current.Name()
+}
+
+// Ping checks that the active backend is reachable
+func Ping(ctx context.Context) error {
+	return current.Ping(ctx)
+}
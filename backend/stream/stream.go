@@ -0,0 +1,250 @@
+// Package stream fans written clicks out to live subscribers. Clicks are
+// delivered in process, and through a Redis channel when Redis is available
+// so subscribers on every instance see clicks handled by any of them.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"shorter-backend/config"
+	"shorter-backend/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisChannel carries click events between instances
+const redisChannel = "shorter:clicks"
+
+// ErrTooManySubscribers is returned when the subscriber limit is reached
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// ErrClosed is returned when subscribing to a closed broker
+var ErrClosed = errors.New("stream closed")
+
+// Event is a click as streamed to subscribers. It leaves out the client's
+// IP address and user agent.
+type Event struct {
+	ID        uint      `json:"id"`
+	URLID     uint      `json:"url_id"`
+	Country   string    `json:"country,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	City      string    `json:"city,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	Device    string    `json:"device,omitempty"`
+	OS        string    `json:"os,omitempty"`
+	Browser   string    `json:"browser,omitempty"`
+	IsBot     bool      `json:"is_bot"`
+	Rule      string    `json:"rule,omitempty"`
+	Variant   string    `json:"variant,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewEvent describes a click for subscribers
+func NewEvent(click *models.Click) Event {
+	return Event{
+		ID:        click.ID,
+		URLID:     click.URLId,
+		Country:   click.Country,
+		Region:    click.Region,
+		City:      click.City,
+		Referer:   click.Referer,
+		Device:    click.Device,
+		OS:        click.OS,
+		Browser:   click.Browser,
+		IsBot:     click.IsBot,
+		Rule:      click.Rule,
+		Variant:   click.Variant,
+		CreatedAt: click.CreatedAt,
+	}
+}
+
+// Subscription receives the events of one link, or of all links
+type Subscription struct {
+	// Events delivers matching events; it is closed when the subscription
+	// ends
+	Events <-chan Event
+
+	events chan Event
+	urlID  uint
+	broker *Broker
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Broker delivers events to subscribers. A subscriber that falls behind
+// loses events rather than holding up the others.
+type Broker struct {
+	maxSubscribers int
+	bufferSize     int
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	dropped atomic.Uint64
+
+	rdb    *redis.Client
+	pubsub *redis.PubSub
+}
+
+// New creates a broker delivering in process only
+func New(maxSubscribers, bufferSize int) *Broker {
+	return &Broker{
+		maxSubscribers: maxSubscribers,
+		bufferSize:     bufferSize,
+		subs:           make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe starts receiving the events of urlID, or of every link when
+// urlID is zero
+func (b *Broker) Subscribe(urlID uint) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if b.maxSubscribers > 0 && len(b.subs) >= b.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	events := make(chan Event, b.bufferSize)
+	sub := &Subscription{Events: events, events: events, urlID: urlID, broker: b}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+}
+
+// deliver hands events to the local subscribers
+func (b *Broker) deliver(events []Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		for _, event := range events {
+			if sub.urlID != 0 && sub.urlID != event.URLID {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				b.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Publish sends events to every subscriber, on all instances when the
+// broker is connected to Redis
+func (b *Broker) Publish(ctx context.Context, events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	if b.rdb != nil {
+		payload, err := json.Marshal(events)
+		if err == nil {
+			err = b.rdb.Publish(ctx, redisChannel, payload).Err()
+		}
+		if err == nil {
+			// Delivered back to this instance through the subscription
+			return
+		}
+		log.Printf("Failed to publish click events to Redis: %v", err)
+	}
+
+	b.deliver(events)
+}
+
+// Record is an analytics sink streaming a written batch
+func (b *Broker) Record(ctx context.Context, clicks []models.Click) {
+	events := make([]Event, len(clicks))
+	for i := range clicks {
+		events[i] = NewEvent(&clicks[i])
+	}
+	b.Publish(ctx, events)
+}
+
+// Dropped counts events subscribers were too slow to receive
+func (b *Broker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Subscribers counts the open subscriptions on this instance
+func (b *Broker) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+// connect relays the events other instances publish to Redis
+func (b *Broker) connect(rdb *redis.Client) {
+	b.rdb = rdb
+	b.pubsub = rdb.Subscribe(context.Background(), redisChannel)
+
+	go func() {
+		for msg := range b.pubsub.Channel() {
+			var events []Event
+			if err := json.Unmarshal([]byte(msg.Payload), &events); err != nil {
+				log.Printf("Ignoring malformed click event: %v", err)
+				continue
+			}
+			b.deliver(events)
+		}
+	}()
+}
+
+// Close ends every subscription and disconnects from Redis
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.events)
+	}
+
+	if b.pubsub != nil {
+		return b.pubsub.Close()
+	}
+	return nil
+}
+
+// Default is the broker fed by the click pipeline
+var Default = New(0, 64)
+
+// Init sizes the default broker from STREAM_MAX_SUBSCRIBERS and
+// STREAM_BUFFER, relaying through Redis when it is connected
+func Init() {
+	Default = New(
+		config.GetEnvInt("STREAM_MAX_SUBSCRIBERS", 1000),
+		config.GetEnvInt("STREAM_BUFFER", 64),
+	)
+	if config.RDB != nil {
+		Default.connect(config.RDB)
+	}
+}
@@ -0,0 +1,125 @@
+package uniques
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// precision is the number of hash bits that pick a register. 2^14 registers
+// give a standard error of about 0.8%, the same as Redis.
+const precision = 14
+
+const registers = 1 << precision
+
+// maxSparse is the number of set registers a sparse sketch holds before it
+// turns dense. At 4 bytes each it stays within an eighth of the dense size.
+const maxSparse = registers / 8
+
+// HLL is a HyperLogLog sketch estimating the number of distinct 64-bit
+// hashes added to it. Like Redis, it starts out sparse, listing only the
+// registers that are set, so sketches of a few visitors stay small.
+type HLL struct {
+	// sparse holds index<<8 | rank of each set register, sorted by index,
+	// until dense is allocated
+	sparse []uint32
+	dense  *[registers]uint8
+}
+
+// NewHLL returns an empty sketch
+func NewHLL() *HLL {
+	return &HLL{}
+}
+
+// Add records a hashed value
+func (h *HLL) Add(hash uint64) {
+	index := uint32(hash >> (64 - precision))
+	// Count leading zeros of the remaining bits; the sentinel bit caps the
+	// run at 64-precision
+	rank := uint8(bits.LeadingZeros64(hash<<precision|1<<(precision-1))) + 1
+	h.set(index, rank)
+}
+
+// set raises register index to rank
+func (h *HLL) set(index uint32, rank uint8) {
+	if h.dense != nil {
+		if rank > h.dense[index] {
+			h.dense[index] = rank
+		}
+		return
+	}
+
+	i := sort.Search(len(h.sparse), func(i int) bool { return h.sparse[i]>>8 >= index })
+	if i < len(h.sparse) && h.sparse[i]>>8 == index {
+		if rank > uint8(h.sparse[i]) {
+			h.sparse[i] = index<<8 | uint32(rank)
+		}
+		return
+	}
+	if len(h.sparse) >= maxSparse {
+		h.densify()
+		h.dense[index] = rank
+		return
+	}
+	h.sparse = append(h.sparse, 0)
+	copy(h.sparse[i+1:], h.sparse[i:])
+	h.sparse[i] = index<<8 | uint32(rank)
+}
+
+// densify switches the sketch to one byte per register
+func (h *HLL) densify() {
+	h.dense = new([registers]uint8)
+	for _, entry := range h.sparse {
+		h.dense[entry>>8] = uint8(entry)
+	}
+	h.sparse = nil
+}
+
+// Merge folds other into h so h estimates the union of both
+func (h *HLL) Merge(other *HLL) {
+	if other.dense == nil {
+		for _, entry := range other.sparse {
+			h.set(entry>>8, uint8(entry))
+		}
+		return
+	}
+	if h.dense == nil {
+		h.densify()
+	}
+	for i, rank := range other.dense {
+		if rank > h.dense[i] {
+			h.dense[i] = rank
+		}
+	}
+}
+
+// Count estimates the number of distinct values added
+func (h *HLL) Count() int64 {
+	const m = float64(registers)
+	alpha := 0.7213 / (1 + 1.079/m)
+
+	var sum float64
+	var zeros int
+	if h.dense != nil {
+		for _, rank := range h.dense {
+			sum += math.Ldexp(1, -int(rank))
+			if rank == 0 {
+				zeros++
+			}
+		}
+	} else {
+		// Registers left out are zero and count 2^0 each
+		zeros = registers - len(h.sparse)
+		sum = float64(zeros)
+		for _, entry := range h.sparse {
+			sum += math.Ldexp(1, -int(uint8(entry)))
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	// Small cardinalities are estimated more accurately by linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
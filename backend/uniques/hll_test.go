@@ -0,0 +1,133 @@
+package uniques
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"shorter-backend/models"
+)
+
+func TestHLLCount(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, maxSparse * 2, 100000} {
+		h := NewHLL()
+		for i := 0; i < n; i++ {
+			h.Add(hashVisitor(fmt.Sprint("visitor-", i)))
+			// Repeats never change the estimate
+			h.Add(hashVisitor(fmt.Sprint("visitor-", i)))
+		}
+
+		got := h.Count()
+		if n == 0 && got != 0 {
+			t.Errorf("Count() of an empty sketch = %d", got)
+		}
+		if n > 0 && math.Abs(float64(got-int64(n)))/float64(n) > 0.03 {
+			t.Errorf("Count() of %d visitors = %d, more than 3%% off", n, got)
+		}
+	}
+}
+
+func TestHLLStaysSparseForFewVisitors(t *testing.T) {
+	h := NewHLL()
+	for i := 0; i < 100; i++ {
+		h.Add(hashVisitor(fmt.Sprint("visitor-", i)))
+	}
+	if h.dense != nil {
+		t.Fatal("sketch of 100 visitors turned dense")
+	}
+
+	for i := 100; i < maxSparse*4; i++ {
+		h.Add(hashVisitor(fmt.Sprint("visitor-", i)))
+	}
+	if h.dense == nil || h.sparse != nil {
+		t.Error("sketch didn't turn dense past maxSparse registers")
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b int
+	}{
+		{"sparse into sparse", 100, 100},
+		{"dense into sparse", 100, 20000},
+		{"sparse into dense", 20000, 100},
+		{"dense into dense", 20000, 20000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The sketches share half of the smaller one's visitors
+			shared := min(tt.a, tt.b) / 2
+			a, b, union := NewHLL(), NewHLL(), NewHLL()
+			for i := 0; i < tt.a; i++ {
+				hash := hashVisitor(fmt.Sprint("a-", i))
+				a.Add(hash)
+				union.Add(hash)
+			}
+			for i := 0; i < tt.b; i++ {
+				visitor := fmt.Sprint("b-", i)
+				if i < shared {
+					visitor = fmt.Sprint("a-", i)
+				}
+				b.Add(hashVisitor(visitor))
+				union.Add(hashVisitor(visitor))
+			}
+
+			a.Merge(b)
+			if got, want := a.Count(), union.Count(); got != want {
+				t.Errorf("Count() after Merge = %d, want %d as if added to one sketch", got, want)
+			}
+		})
+	}
+}
+
+func TestMemoryCounterExpiresBuckets(t *testing.T) {
+	ctx := context.Background()
+	counter := newMemoryCounter(7 * 24 * time.Hour)
+	now := time.Now().UTC()
+
+	counter.Add(ctx, 1, now, "a", "b")
+	counter.Add(ctx, 1, now.Add(-9*24*time.Hour), "c")
+
+	count := func(granularity string, bucket time.Time) int64 {
+		t.Helper()
+		n, err := counter.CountBucket(ctx, 1, granularity, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// Buckets past their retention are never created
+	if n := count(models.GranularityDay, now.Add(-9*24*time.Hour)); n != 0 {
+		t.Errorf("expired day counted %d visitors", n)
+	}
+	if n := count(models.GranularityDay, now); n != 2 {
+		t.Errorf("day counted %d visitors, want 2", n)
+	}
+
+	// Hours go first, then days; the total is kept
+	counter.mu.Lock()
+	counter.expire(now.Add(hourRetention + time.Hour))
+	counter.mu.Unlock()
+	if n := count(models.GranularityHour, now); n != 0 {
+		t.Errorf("hour still counted %d visitors after its retention", n)
+	}
+	if n := count(models.GranularityDay, now); n != 2 {
+		t.Errorf("day counted %d visitors before its retention ended, want 2", n)
+	}
+
+	counter.mu.Lock()
+	counter.expire(now.Add(9 * 24 * time.Hour))
+	remaining := len(counter.sketches)
+	counter.mu.Unlock()
+	if remaining != 1 {
+		t.Errorf("%d sketches left after every bucket expired, want only the total", remaining)
+	}
+	if n, _ := counter.Count(ctx, 1); n != 3 {
+		t.Errorf("total counted %d visitors, want 3", n)
+	}
+}
@@ -0,0 +1,301 @@
+// Package uniques estimates unique visitors per link with HyperLogLog
+// sketches, kept in Redis when it is available and in process otherwise.
+package uniques
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"shorter-backend/analytics"
+	"shorter-backend/config"
+	"shorter-backend/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Counter records visitors and estimates how many distinct ones a link had
+type Counter interface {
+	// Add records visitors on the link's sketches for the hour and day of
+	// at and for all time
+	Add(ctx context.Context, urlID uint, at time.Time, visitors ...string) error
+	// Count estimates the link's distinct visitors of all time
+	Count(ctx context.Context, urlID uint) (int64, error)
+	// CountBucket estimates the link's distinct visitors in the hour or day
+	// (models.GranularityHour or GranularityDay) starting at bucket
+	CountBucket(ctx context.Context, urlID uint, granularity string, bucket time.Time) (int64, error)
+}
+
+// Bucket sketch names; buckets are UTC
+const (
+	dayFormat  = "2006-01-02"
+	hourFormat = "2006-01-02T15"
+)
+
+// hourRetention is how long hourly sketches are kept. They are only read
+// while their hour can still receive clicks.
+const hourRetention = 48 * time.Hour
+
+func totalKey(urlID uint) string {
+	return fmt.Sprintf("uniques:%d", urlID)
+}
+
+func dayKey(urlID uint, at time.Time) string {
+	return fmt.Sprintf("uniques:%d:%s", urlID, at.UTC().Format(dayFormat))
+}
+
+func hourKey(urlID uint, at time.Time) string {
+	return fmt.Sprintf("uniques:%d:%s", urlID, at.UTC().Format(hourFormat))
+}
+
+// bucketKey names the sketch of a rollup bucket
+func bucketKey(urlID uint, granularity string, bucket time.Time) (string, error) {
+	switch granularity {
+	case models.GranularityHour:
+		return hourKey(urlID, bucket), nil
+	case models.GranularityDay:
+		return dayKey(urlID, bucket), nil
+	}
+	return "", fmt.Errorf("unknown granularity %q", granularity)
+}
+
+// Fingerprint identifies a visitor without storing who they are: a salted
+// hash of the client IP and user agent
+func Fingerprint(salt, ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(salt + "\x00" + ip + "\x00" + userAgent))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Visitor fingerprints a client with the configured salt
+func Visitor(ip, userAgent string) string {
+	return Fingerprint(salt, ip, userAgent)
+}
+
+// hashVisitor maps a fingerprint onto the 64-bit hash the in-process
+// sketches use
+func hashVisitor(visitor string) uint64 {
+	sum := sha256.Sum256([]byte(visitor))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// redisCounter keeps the sketches in Redis with PFADD/PFCOUNT so every
+// instance contributes to the same counts
+type redisCounter struct {
+	rdb       *redis.Client
+	retention time.Duration
+}
+
+func (r *redisCounter) Add(ctx context.Context, urlID uint, at time.Time, visitors ...string) error {
+	members := make([]interface{}, len(visitors))
+	for i, v := range visitors {
+		members[i] = v
+	}
+
+	day, hour := dayKey(urlID, at), hourKey(urlID, at)
+	pipe := r.rdb.Pipeline()
+	pipe.PFAdd(ctx, totalKey(urlID), members...)
+	pipe.PFAdd(ctx, day, members...)
+	pipe.Expire(ctx, day, r.retention)
+	pipe.PFAdd(ctx, hour, members...)
+	pipe.Expire(ctx, hour, hourRetention)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *redisCounter) Count(ctx context.Context, urlID uint) (int64, error) {
+	return r.rdb.PFCount(ctx, totalKey(urlID)).Result()
+}
+
+func (r *redisCounter) CountBucket(ctx context.Context, urlID uint, granularity string, bucket time.Time) (int64, error) {
+	key, err := bucketKey(urlID, granularity, bucket)
+	if err != nil {
+		return 0, err
+	}
+	return r.rdb.PFCount(ctx, key).Result()
+}
+
+// memoryCounter keeps the sketches in process, for single instances
+// running without Redis
+type memoryCounter struct {
+	retention time.Duration
+
+	mu       sync.Mutex
+	sketches map[string]*HLL
+	// expiries orders the bucket sketches by when they may be dropped
+	expiries expiryQueue
+}
+
+func newMemoryCounter(retention time.Duration) *memoryCounter {
+	return &memoryCounter{
+		retention: retention,
+		sketches:  make(map[string]*HLL),
+	}
+}
+
+// bucketSketch returns the sketch of a bucket that expires at expires,
+// creating it if needed, or nil once the bucket has expired
+func (m *memoryCounter) bucketSketch(key string, expires, now time.Time) *HLL {
+	if h, ok := m.sketches[key]; ok {
+		return h
+	}
+	if !now.Before(expires) {
+		return nil
+	}
+	h := NewHLL()
+	m.sketches[key] = h
+	heap.Push(&m.expiries, expiry{key: key, at: expires})
+	return h
+}
+
+func (m *memoryCounter) Add(ctx context.Context, urlID uint, at time.Time, visitors ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.expire(now)
+
+	// A bucket is kept for the retention after it ends, which is when Redis
+	// would drop a sketch last written at the end of its bucket
+	day := at.UTC().Truncate(24 * time.Hour)
+	hour := at.UTC().Truncate(time.Hour)
+	total, ok := m.sketches[totalKey(urlID)]
+	if !ok {
+		total = NewHLL()
+		m.sketches[totalKey(urlID)] = total
+	}
+	daily := m.bucketSketch(dayKey(urlID, at), day.Add(24*time.Hour+m.retention), now)
+	hourly := m.bucketSketch(hourKey(urlID, at), hour.Add(time.Hour+hourRetention), now)
+
+	for _, v := range visitors {
+		hash := hashVisitor(v)
+		total.Add(hash)
+		if daily != nil {
+			daily.Add(hash)
+		}
+		if hourly != nil {
+			hourly.Add(hash)
+		}
+	}
+	return nil
+}
+
+// expire drops bucket sketches past their retention, like Redis would.
+// Only sketches that are due are looked at.
+func (m *memoryCounter) expire(now time.Time) {
+	for m.expiries.Len() > 0 && !now.Before(m.expiries[0].at) {
+		delete(m.sketches, heap.Pop(&m.expiries).(expiry).key)
+	}
+}
+
+// expiry is when the bucket sketch under key may be dropped
+type expiry struct {
+	key string
+	at  time.Time
+}
+
+// expiryQueue is a min-heap of expiries, soonest first
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiry)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+func (m *memoryCounter) Count(ctx context.Context, urlID uint) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.sketches[totalKey(urlID)]
+	if !ok {
+		return 0, nil
+	}
+	return h.Count(), nil
+}
+
+func (m *memoryCounter) CountBucket(ctx context.Context, urlID uint, granularity string, bucket time.Time) (int64, error) {
+	key, err := bucketKey(urlID, granularity, bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.sketches[key]
+	if !ok {
+		return 0, nil
+	}
+	return h.Count(), nil
+}
+
+// Default is the counter used for click analytics
+var Default Counter = newMemoryCounter(90 * 24 * time.Hour)
+
+// salt keys visitor fingerprints
+var salt string
+
+// Init picks Redis or in-process sketches and loads the fingerprint salt
+// from VISITOR_SALT. Without a configured salt a random one is used, so
+// visitors are only recognised until the next restart.
+func Init() {
+	retention := config.GetEnvDuration("UNIQUE_VISITOR_RETENTION", 90*24*time.Hour)
+	if config.RDB != nil {
+		Default = &redisCounter{rdb: config.RDB, retention: retention}
+	} else {
+		Default = newMemoryCounter(retention)
+	}
+
+	salt = config.GetEnv("VISITOR_SALT", "")
+	if salt == "" {
+		buf := make([]byte, 16)
+		rand.Read(buf)
+		salt = hex.EncodeToString(buf)
+		log.Println("VISITOR_SALT not set, unique visitors are counted per process lifetime")
+	}
+}
+
+// Enrich is an analytics enricher that fingerprints the click's visitor
+func Enrich(hit *analytics.Hit) {
+	hit.Click.VisitorID = Visitor(hit.Click.IPAddress, hit.Click.UserAgent)
+}
+
+// Record is an analytics sink adding the human visitors of a written
+// batch to the sketches
+func Record(ctx context.Context, clicks []models.Click) {
+	type bucket struct {
+		urlID uint
+		hour  string
+	}
+
+	// One round trip per link and hour instead of per click
+	visitors := make(map[bucket][]string)
+	times := make(map[bucket]time.Time)
+	for i := range clicks {
+		click := &clicks[i]
+		if click.IsBot || click.VisitorID == "" {
+			continue
+		}
+		b := bucket{click.URLId, click.CreatedAt.UTC().Format(hourFormat)}
+		visitors[b] = append(visitors[b], click.VisitorID)
+		times[b] = click.CreatedAt
+	}
+
+	for b, ids := range visitors {
+		if err := Default.Add(ctx, b.urlID, times[b], ids...); err != nil {
+			log.Printf("Failed to record unique visitors for URL %d: %v", b.urlID, err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Package useragent classifies User-Agent strings into browser, operating
+// system and device class for click analytics.
+package useragent
+
+import (
+	"strings"
+
+	"shorter-backend/analytics"
+)
+
+// Device classes
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// Operating systems
+const (
+	OSWindows      = "Windows"
+	OSWindowsPhone = "Windows Phone"
+	OSIOS          = "iOS"
+	OSIPadOS       = "iPadOS"
+	OSAndroid      = "Android"
+	OSChromeOS     = "ChromeOS"
+	OSMacOS        = "macOS"
+	OSLinux        = "Linux"
+	OSBSD          = "BSD"
+)
+
+// Devices and OperatingSystems list every class and system Parse reports
+var (
+	Devices          = []string{DeviceDesktop, DeviceMobile, DeviceTablet, DeviceBot}
+	OperatingSystems = []string{OSWindows, OSWindowsPhone, OSIOS, OSIPadOS, OSAndroid, OSChromeOS, OSMacOS, OSLinux, OSBSD}
+)
+
+// Agent is what a User-Agent string says about the client
+type Agent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	Device         string
+}
+
+// botTokens mark automated clients. Matching is case-insensitive.
+var botTokens = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "facebookcatalog",
+	"embedly", "preview", "monitor", "uptime", "pingdom", "headless",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client",
+	"java/", "okhttp", "libwww-perl", "httpclient", "axios/", "node-fetch",
+}
+
+// browser is a product token and the name it is reported as. Order matters:
+// most browsers also claim to be Chrome and Safari, so the specific ones
+// come first.
+type browser struct {
+	token string
+	name  string
+}
+
+var browsers = []browser{
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edg/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"OPiOS/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"YaBrowser/", "Yandex Browser"},
+	{"UCBrowser/", "UC Browser"},
+	{"Vivaldi/", "Vivaldi"},
+	{"FxiOS/", "Firefox"},
+	{"Firefox/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"MSIE ", "Internet Explorer"},
+}
+
+// Parse classifies ua. Fields it cannot determine are left empty.
+func Parse(ua string) Agent {
+	var agent Agent
+	ua = strings.TrimSpace(ua)
+	if ua == "" {
+		return agent
+	}
+
+	agent.OS = parseOS(ua)
+
+	if name, ok := botName(ua); ok {
+		agent.Browser = name
+		agent.Device = DeviceBot
+		return agent
+	}
+
+	agent.Browser, agent.BrowserVersion = parseBrowser(ua)
+	agent.Device = parseDevice(ua)
+	return agent
+}
+
+// IsBot reports whether ua identifies an automated client
+func IsBot(ua string) bool {
+	_, ok := botName(ua)
+	return ok
+}
+
+// botName reports whether ua is an automated client and, if so, the
+// product name it identifies itself with
+func botName(ua string) (string, bool) {
+	lower := strings.ToLower(ua)
+	for _, token := range botTokens {
+		if strings.Contains(lower, token) {
+			return productName(ua, token), true
+		}
+	}
+	return "", false
+}
+
+// productName picks the product the bot token belongs to, e.g. "Googlebot"
+// from "Mozilla/5.0 (compatible; Googlebot/2.1; ...)"
+func productName(ua, token string) string {
+	lower := strings.ToLower(ua)
+	name := strings.TrimRight(token, "/ ")
+	i := strings.Index(lower, name)
+	if i < 0 || len(lower) != len(ua) {
+		return name
+	}
+
+	// Walk back to the start of the product and forward to its end
+	start := strings.LastIndexAny(ua[:i], " ;(,") + 1
+	end := i + strings.IndexAny(ua[i:]+" ", " /;),")
+	return ua[start:end]
+}
+
+func parseBrowser(ua string) (string, string) {
+	for _, b := range browsers {
+		if i := strings.Index(ua, b.token); i >= 0 {
+			return b.name, version(ua[i+len(b.token):])
+		}
+	}
+
+	if strings.Contains(ua, "Trident/") {
+		if i := strings.Index(ua, "rv:"); i >= 0 {
+			return "Internet Explorer", version(ua[i+3:])
+		}
+		return "Internet Explorer", ""
+	}
+
+	// Safari reports its version separately from the WebKit build
+	if strings.Contains(ua, "Safari/") || strings.Contains(ua, "AppleWebKit/") {
+		if i := strings.Index(ua, "Version/"); i >= 0 {
+			return "Safari", version(ua[i+len("Version/"):])
+		}
+		if strings.Contains(ua, "Mobile/") {
+			return "Safari", ""
+		}
+	}
+
+	return "", ""
+}
+
+// version reads a version number from the start of s
+func version(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !(r == '.' || r >= '0' && r <= '9')
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	return strings.TrimRight(s[:end], ".")
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "Windows Phone"):
+		return OSWindowsPhone
+	case strings.Contains(ua, "Windows"):
+		return OSWindows
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPod"):
+		return OSIOS
+	case strings.Contains(ua, "iPad"):
+		return OSIPadOS
+	case strings.Contains(ua, "Android"):
+		return OSAndroid
+	case strings.Contains(ua, "CrOS"):
+		return OSChromeOS
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		return OSMacOS
+	case strings.Contains(ua, "Linux"):
+		return OSLinux
+	case strings.Contains(ua, "FreeBSD"), strings.Contains(ua, "OpenBSD"):
+		return OSBSD
+	}
+	return ""
+}
+
+func parseDevice(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPad"), strings.Contains(ua, "Tablet"),
+		strings.Contains(ua, "Kindle"), strings.Contains(ua, "Silk/"):
+		return DeviceTablet
+	case strings.Contains(ua, "Android"):
+		// Android phones say "Mobile"; tablets don't
+		if strings.Contains(ua, "Mobile") {
+			return DeviceMobile
+		}
+		return DeviceTablet
+	case strings.Contains(ua, "Mobi"), strings.Contains(ua, "iPhone"),
+		strings.Contains(ua, "iPod"), strings.Contains(ua, "Windows Phone"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// Enrich is an analytics enricher that fills in the click's client details
+func Enrich(hit *analytics.Hit) {
+	agent := Parse(hit.Click.UserAgent)
+	hit.Click.Browser = agent.Browser
+	hit.Click.BrowserVersion = agent.BrowserVersion
+	hit.Click.OS = agent.OS
+	hit.Click.Device = agent.Device
+}
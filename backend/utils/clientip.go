@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustedProxies are the networks whose forwarding header is believed
+var trustedProxies []*net.IPNet
+
+// proxyHeader is the one header the trusted proxies set to report the
+// client; any other forwarding header is the client's own and ignored
+var proxyHeader = "X-Forwarded-For"
+
+// SetTrustedProxies sets the proxies allowed to report client addresses
+// from a comma-separated list of CIDRs or single addresses. Forwarding
+// headers from anywhere else are ignored.
+func SetTrustedProxies(list string) error {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		cidr := entry
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxies = networks
+	return nil
+}
+
+// SetTrustedProxyHeader sets the header the trusted proxies report client
+// addresses in: X-Forwarded-For, Forwarded (RFC 7239), or a header holding
+// a single address such as X-Real-IP or CF-Connecting-IP. Proxies pass on
+// headers they don't set themselves, so only the one they do set is read.
+func SetTrustedProxyHeader(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, " \t:,") {
+		return fmt.Errorf("invalid trusted proxy header %q", name)
+	}
+	proxyHeader = http.CanonicalHeaderKey(name)
+	return nil
+}
+
+// isTrustedProxy reports whether ip belongs to a trusted proxy
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClientIP returns the address of the client that made the request.
+// Requests from trusted proxies are attributed to the address in the proxy
+// header, whose list is walked from the nearest hop outwards: the first
+// address that isn't a trusted proxy wins, so clients can't choose their
+// address by sending the header themselves.
+func GetClientIP(r *http.Request) string {
+	remote := parseHostIP(r.RemoteAddr)
+	if remote == nil {
+		return r.RemoteAddr
+	}
+	if !isTrustedProxy(remote) {
+		return remote.String()
+	}
+
+	var hops []string
+	if proxyHeader == "Forwarded" {
+		hops = forwardedFor(r.Header)
+	} else {
+		hops = headerList(r.Header, proxyHeader)
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHostIP(hops[i])
+		if ip == nil {
+			// Obfuscated or garbled; the proxy that added it is the best we know
+			break
+		}
+		client = ip
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the for= addresses of the Forwarded header, nearest
+// client first, e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, element := range headerList(header, "Forwarded") {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				hops = append(hops, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// headerList splits every value of a comma-separated header into its
+// elements, in order
+func headerList(header http.Header, name string) []string {
+	var elements []string
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return elements
+}
+
+// parseHostIP parses an address with or without a port, such as
+// "192.0.2.1", "192.0.2.1:80", "2001:db8::1" or "[2001:db8::1]:80"
+func parseHostIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	// Drop the zone of link-local IPv6 addresses
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	return net.ParseIP(addr)
+}
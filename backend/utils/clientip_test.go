@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGetClientIP(t *testing.T) {
+	if err := SetTrustedProxies("10.0.0.0/8, 127.0.0.1, ::1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		SetTrustedProxies("")
+		SetTrustedProxyHeader("X-Forwarded-For")
+	})
+
+	tests := []struct {
+		name    string
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "direct client",
+			remote: "203.0.113.7:5000",
+			want:   "203.0.113.7",
+		},
+		{
+			name:    "untrusted remote ignores forwarding headers",
+			remote:  "203.0.113.7:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "trusted proxy with X-Forwarded-For",
+			remote:  "10.0.0.2:80",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "client-sent X-Forwarded-For entries are left of the real one",
+			remote:  "10.0.0.2:80",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "trusted hops are skipped",
+			remote:  "127.0.0.1:80",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9, 10.1.2.3, 10.0.0.2"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "client-sent Forwarded is ignored",
+			remote:  "10.0.0.2:80",
+			headers: map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "client-sent X-Real-IP is ignored",
+			remote:  "10.0.0.2:80",
+			headers: map[string]string{"X-Real-IP": "1.2.3.4"},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "only proxies in the chain",
+			remote:  "10.0.0.2:80",
+			headers: map[string]string{"X-Forwarded-For": "10.0.0.5"},
+			want:    "10.0.0.5",
+		},
+		{
+			name:    "garbled hop stops the walk",
+			remote:  "10.0.0.2:80",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9, unknown"},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "configured Forwarded header",
+			header:  "Forwarded",
+			remote:  "[::1]:80",
+			headers: map[string]string{"Forwarded": `for=1.2.3.4, for="[2001:db8::1]:4711";proto=https`, "X-Forwarded-For": "1.2.3.4"},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "configured single-address header",
+			header:  "CF-Connecting-IP",
+			remote:  "10.0.0.2:80",
+			headers: map[string]string{"Cf-Connecting-Ip": "198.51.100.9", "X-Forwarded-For": "1.2.3.4"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:   "IPv6 remote with zone",
+			remote: "[fe80::1%eth0]:80",
+			want:   "fe80::1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = "X-Forwarded-For"
+			}
+			if err := SetTrustedProxyHeader(header); err != nil {
+				t.Fatal(err)
+			}
+
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			if got := GetClientIP(r); got != tt.want {
+				t.Errorf("GetClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	t.Cleanup(func() { SetTrustedProxies("") })
+
+	for _, list := range []string{"", "10.0.0.0/8", "192.0.2.1, 2001:db8::/32"} {
+		if err := SetTrustedProxies(list); err != nil {
+			t.Errorf("SetTrustedProxies(%q) = %v", list, err)
+		}
+	}
+	for _, list := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if err := SetTrustedProxies(list); err == nil {
+			t.Errorf("SetTrustedProxies(%q) accepted", list)
+		}
+	}
+}
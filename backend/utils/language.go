@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PreferredLanguage returns the language tag an Accept-Language header
+// ranks highest, lowercased, or "" when it names none. Ties go to the tag
+// listed first and the "*" wildcard is skipped.
+func PreferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(param, "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// LanguageMatches reports whether the language range matches tag: they are
+// equal, or tag is a more specific form of it ("en" matches "en-us")
+func LanguageMatches(languageRange, tag string) bool {
+	languageRange = strings.ToLower(languageRange)
+	return tag == languageRange || strings.HasPrefix(tag, languageRange+"-")
+}
@@ -3,11 +3,9 @@ package utils
 import (
 	"crypto/rand"
 	"math/big"
-	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/google/uuid"
 )
@@ -91,47 +89,6 @@ func IsValidCustomCode(code string) bool {
 	return matched
 }
 
-// GetClientIP extracts the real client IP from request
-func GetClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		// X-Forwarded-For can contain multiple IPs, get the first one
-		ips := strings.Split(xff, ",")
-		return strings.TrimSpace(ips[0])
-	}
-
-	// Check X-Real-IP header
-	xri := r.Header.Get("X-Real-IP")
-	if xri != "" {
-		return xri
-	}
-
-	// Fallback to RemoteAddr
-	ip := r.RemoteAddr
-	if strings.Contains(ip, ":") {
-		ip = strings.Split(ip, ":")[0]
-	}
-	return ip
-}
-
-// GetTitleFromURL fetches the title of a webpage (simplified version)
-func GetTitleFromURL(rawURL string) string {
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	resp, err := client.Get(rawURL)
-	if err != nil {
-		return ""
-	}
-	defer resp.Body.Close()
-
-	// Simple title extraction (you might want to use a proper HTML parser)
-	// For now, return empty string - you can enhance this later
-	return ""
-}
-
 // Contains checks if a slice contains a string
 func Contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateShortCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		code := GenerateShortCode()
+		if len(code) != shortCodeLength {
+			t.Fatalf("GenerateShortCode() = %q, want %d characters", code, shortCodeLength)
+		}
+		for _, r := range code {
+			if !strings.ContainsRune(base62Chars, r) {
+				t.Fatalf("GenerateShortCode() = %q, contains %q outside Base62", code, r)
+			}
+		}
+		seen[code] = true
+	}
+	// 62^6 codes make a repeat within a thousand draws all but impossible
+	if len(seen) < 999 {
+		t.Errorf("GenerateShortCode() repeated itself: %d distinct codes in 1000", len(seen))
+	}
+}
+
+func TestBase62RoundTrip(t *testing.T) {
+	for _, n := range []uint32{0, 1, 61, 62, 3843, 3844, 1<<32 - 1} {
+		encoded := EncodeBase62(n)
+		if got := DecodeBase62(encoded); got != n {
+			t.Errorf("DecodeBase62(EncodeBase62(%d) = %q) = %d", n, encoded, got)
+		}
+	}
+}
+
+func TestIsValidCustomCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{"abc", true},
+		{"my-link-2024", true},
+		{"ab", false},
+		{strings.Repeat("a", 20), true},
+		{strings.Repeat("a", 21), false},
+		{"has space", false},
+		{"under_score", false},
+		{"slash/code", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidCustomCode(tt.code); got != tt.want {
+			t.Errorf("IsValidCustomCode(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"example.com", "http://example.com", true},
+		{"https://example.com/path?q=1", "https://example.com/path?q=1", true},
+		{"http://example.com", "http://example.com", true},
+		{"", "http://", false},
+	}
+	for _, tt := range tests {
+		got := NormalizeURL(tt.in)
+		if got != tt.want {
+			t.Errorf("NormalizeURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if valid := IsValidURL(got); valid != tt.valid {
+			t.Errorf("IsValidURL(%q) = %v, want %v", got, valid, tt.valid)
+		}
+	}
+}